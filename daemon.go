@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mlsteele/builderator/engine"
+)
+
+// Set in the environment of the background builderator started by -daemon,
+// so it runs instead of starting another.
+const DAEMON_CHILD_ENV = "BUILDERATOR_DAEMON_CHILD"
+
+// How long -daemon waits for the background builderator to take its locks.
+const DAEMON_START_TIMEOUT = 5 * time.Second
+
+// DaemonLogPath returns where -daemon logs for the config at cpath without -log-file:
+// .builderator.toml logs to .builderator.log next to it.
+func DaemonLogPath(cpath string) string {
+	name := strings.TrimSuffix(path.Base(cpath), ".toml")
+	return path.Join(path.Dir(cpath), name+".log")
+}
+
+// Whether this process is the background builderator started by -daemon.
+func isDaemonChild() bool {
+	return os.Getenv(DAEMON_CHILD_ENV) != ""
+}
+
+// Start this builderator again in the background with the same arguments,
+// in a new session with no terminal and its output appended to logPath.
+// Waits until it holds the locks for `cs` so that problems starting show up here.
+// Only works where setsid does, so not on Windows.
+func daemonize(cs []engine.Config, logPath string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("could not find the builderator executable: %v", err)
+	}
+	logFile, err := os.OpenFile(logPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("could not open log file: %v", err)
+	}
+	defer logFile.Close()
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		return err
+	}
+	defer devNull.Close()
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), DAEMON_CHILD_ENV+"=1")
+	cmd.Stdin = devNull
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	err = cmd.Start()
+	if err != nil {
+		return fmt.Errorf("could not start in the background: %v", err)
+	}
+	pid := cmd.Process.Pid
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	deadline := time.After(DAEMON_START_TIMEOUT)
+	for {
+		started := true
+		for _, c := range cs {
+			holder, _ := LockHolder(c.ConfigPath, c.Task)
+			if holder != pid {
+				started = false
+			}
+		}
+		if started {
+			break
+		}
+		select {
+		case err := <-exited:
+			return fmt.Errorf("background builderator exited: %v, see %v", err, logPath)
+		case <-deadline:
+			logWarn("background builderator (pid %v) is slow to start, see %v", pid, logPath)
+			return nil
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	logInfo("Running in the background (pid %v), logging to %v", pid, logPath)
+	logInfo("Stop it with: %v stop", os.Args[0])
+	return nil
+}
@@ -0,0 +1,107 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AggregateState combines the states of several tasks into one.
+// Failed if any task failed, otherwise building if any is warming, building or canceling,
+// otherwise ok if every task succeeded, otherwise idle.
+func AggregateState(states []string) string {
+	if len(states) == 0 {
+		return STATE_IDLE
+	}
+	busy := false
+	allOK := true
+	for _, state := range states {
+		switch state {
+		case STATE_FAILED:
+			return STATE_FAILED
+		case STATE_WARMING, STATE_BUILDING, STATE_CANCELING:
+			busy = true
+		}
+		if state != STATE_OK {
+			allOK = false
+		}
+	}
+	switch {
+	case busy:
+		return STATE_BUILDING
+	case allOK:
+		return STATE_OK
+	default:
+		return STATE_IDLE
+	}
+}
+
+// Aggregator publishes the combined state of several tasks
+// to a shared status bar and an aggregate status file.
+// Set Runner.Aggregators to feed it.
+type Aggregator struct {
+	// Shared by the tasks, which then don't set its color themselves. May be nil.
+	StatusBar *StatusBar
+	// What StatusBar shows for each combined state, like Config.StatusBarColors.
+	// nil for the AnyBar defaults.
+	StatusBarColors map[string]string
+	// Where to write the combined state of every task. May be nil.
+	StatusFile     *string
+	StatusFileMode os.FileMode
+	// Like Config.StatusWriteInterval.
+	StatusWriteInterval time.Duration
+
+	mu     sync.Mutex
+	tasks  []string
+	states map[string]string
+	// The last combined state published.
+	published string
+}
+
+// NewAggregator makes an Aggregator for the named tasks, all starting idle.
+func NewAggregator(tasks []string) *Aggregator {
+	a := &Aggregator{
+		StatusFileMode: DEFAULT_STATUS_FILE_MODE,
+		tasks:          tasks,
+		states:         make(map[string]string),
+	}
+	for _, task := range tasks {
+		a.states[task] = STATE_IDLE
+	}
+	return a
+}
+
+// Update records a task's new state and publishes the combined state if it changed.
+func (a *Aggregator) Update(task string, state string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.states[task] = state
+	var states []string
+	for _, t := range a.tasks {
+		states = append(states, a.states[t])
+	}
+	combined := AggregateState(states)
+	if a.StatusFile != nil {
+		writeStatus(*a.StatusFile, a.StatusFileMode, a.StatusWriteInterval, a.formatStatus(combined))
+	}
+	if combined == a.published {
+		return
+	}
+	a.published = combined
+	if a.StatusBar != nil {
+		a.StatusBar.SetLatest(statusBarColor(a.StatusBarColors, combined))
+	}
+}
+
+// The combined state followed by a line for each task.
+func (a *Aggregator) formatStatus(combined string) string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%v\n\n", strings.ToUpper(combined))
+	for _, t := range a.tasks {
+		fmt.Fprintf(&b, "%v: %v\n", t, a.states[t])
+	}
+	return b.String()
+}
@@ -0,0 +1,24 @@
+package engine
+
+import "testing"
+
+func TestAggregateState(t *testing.T) {
+	cases := []struct {
+		states   []string
+		expected string
+	}{
+		{nil, STATE_IDLE},
+		{[]string{STATE_OK, STATE_OK}, STATE_OK},
+		{[]string{STATE_OK, STATE_IDLE}, STATE_IDLE},
+		{[]string{STATE_OK, STATE_BUILDING}, STATE_BUILDING},
+		{[]string{STATE_CANCELING, STATE_IDLE}, STATE_BUILDING},
+		{[]string{STATE_WARMING, STATE_OK}, STATE_BUILDING},
+		{[]string{STATE_BUILDING, STATE_FAILED}, STATE_FAILED},
+		{[]string{STATE_OK, STATE_FAILED, STATE_OK}, STATE_FAILED},
+	}
+	for _, tc := range cases {
+		if got := AggregateState(tc.states); got != tc.expected {
+			t.Errorf("%v => %v, expected %v", tc.states, got, tc.expected)
+		}
+	}
+}
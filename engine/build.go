@@ -0,0 +1,503 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"text/template"
+	"time"
+)
+
+// BuildCmdContext is the data available to BuildCmd as a text/template.
+type BuildCmdContext struct {
+	// Files changed since the last completed build, relative to WatchDir.
+	ChangedFiles []string
+	WatchDir     string
+	ConfigPath   string
+	// The changed Go package for GoPackageCmd, relative to BuildCmdDir like "./foo/bar".
+	// "" for other commands.
+	Package string
+}
+
+// ChangedFile is the first changed file, or "" if there are none.
+func (t BuildCmdContext) ChangedFile() string {
+	if len(t.ChangedFiles) == 0 {
+		return ""
+	}
+	return t.ChangedFiles[0]
+}
+
+type BuildResult struct {
+	Error  error
+	Stdout string
+	Stderr string
+	// How long the build ran for.
+	Duration time.Duration
+	// The last Config.TooltipLines lines of the output, set by report.
+	Tail string
+}
+
+// Output is stdout and stderr combined.
+func (r BuildResult) Output() string {
+	return r.Stdout + r.Stderr
+}
+
+// Add newly changed files to a list, skipping ones already present.
+func mergeChanged(changed []string, files []string) []string {
+	for _, f := range files {
+		dup := false
+		for _, g := range changed {
+			if f == g {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			changed = append(changed, f)
+		}
+	}
+	return changed
+}
+
+// Kick off a build, rerunning it up to BuildRetries times while it fails.
+// Only the final result is returned on the channel.
+// PreBuildCmd runs again before each retry.
+// Canceling ctx aborts the build and any retries.
+func buildWithRetries(ctx context.Context, c Config, runner CommandRunner, changed []string, warmed chan<- struct{}, progress io.Writer) <-chan BuildResult {
+	if c.BuildRetries == 0 {
+		return build(ctx, c, runner, changed, warmed, progress)
+	}
+	resultCh := make(chan BuildResult, 1)
+	go func() {
+		res := <-build(ctx, c, runner, changed, warmed, progress)
+		for retry := 1; retry <= c.BuildRetries && res.Error != nil && ctx.Err() == nil; retry++ {
+			fields := logFields{"event": "retry", "retry": retry, "error": res.Error.Error()}
+			if c.Task != "" {
+				fields["task"] = c.Task
+			}
+			logInfoFields(fields, "%vBuild failed, retrying in %v (%v of %v): %v", taskPrefix(c), c.BuildRetryDelay, retry, c.BuildRetries, res.Error)
+			select {
+			case <-ctx.Done():
+				res = BuildResult{
+					Error: fmt.Errorf("Build canceled"),
+				}
+			case <-time.After(c.BuildRetryDelay):
+				res = <-build(ctx, c, runner, changed, warmed, progress)
+			}
+		}
+		resultCh <- res
+	}()
+	return resultCh
+}
+
+// Kick off a single build run, started with `runner`, or locally if it is nil.
+// `changed` is the absolute paths of the files that triggered the build.
+// With PreBuildCmd, that runs first and `warmed` gets a value, if there is room,
+// once it succeeded and the build proper starts. `warmed` may be nil.
+// With StreamOutput the output is also written to `progress` as it streams, unless it is nil.
+// Canceling ctx aborts the build by signaling it.
+// A single result is always returned on the channel even when aborted.
+func build(ctx context.Context, c Config, runner CommandRunner, changed []string, warmed chan<- struct{}, progress io.Writer) <-chan BuildResult {
+	if runner == nil {
+		runner = ExecCommandRunner{}
+	}
+	if c.UsePTY {
+		runner = ptyCommandRunner{runner}
+	}
+	resultCh := make(chan BuildResult, 1)
+
+	// Replace the targets with justasec so that running one while building waits for the build.
+	if c.UseJustASec {
+		for _, p := range c.BuildFiles {
+			err := justasec(p)
+			if err != nil {
+				logWarn("could not replace BuildFile %v with justasec: %v", p, err)
+			}
+		}
+	}
+
+	rel := relChanged(c, changed)
+	preCmd, buildCmd, buildArgv, err := renderCommands(c, rel)
+	if err != nil {
+		resultCh <- BuildResult{Error: err}
+		return resultCh
+	}
+
+	var fileEnv []string
+	if c.BuildEnvFile != nil {
+		fileEnv, err = readEnvFile(*c.BuildEnvFile)
+		if err != nil {
+			resultCh <- BuildResult{
+				Error: fmt.Errorf("Could not read BuildEnvFile: %v", err),
+			}
+			return resultCh
+		}
+	}
+
+	if preCmd == "" {
+		return runBuildCmd(ctx, c, runner, buildCmd, buildArgv, rel, fileEnv, progress)
+	}
+	go func() {
+		pre := <-runBuildCmd(ctx, c, runner, preCmd, []string{"bash", "-c", preCmd}, rel, fileEnv, progress)
+		if pre.Error != nil {
+			if ctx.Err() == nil {
+				logInfo("%vPreBuildCmd failed: %v", taskPrefix(c), pre.Error)
+			}
+			resultCh <- pre
+			return
+		}
+		select {
+		case warmed <- struct{}{}:
+		default:
+		}
+		res := <-runBuildCmd(ctx, c, runner, buildCmd, buildArgv, rel, fileEnv, progress)
+		res.Duration += pre.Duration
+		resultCh <- res
+	}()
+	return resultCh
+}
+
+// Render what a build with the changed files `rel` runs, after Rules and GoPackageScoped:
+// PreBuildCmd, or "" for none, and BuildCmd as run by bash and as the argv it runs with.
+func renderCommands(c Config, rel []string) (preCmd string, buildCmd string, buildArgv []string, err error) {
+	ruleCmd, routed, err := renderRuleCmds(c, rel)
+	var pkgCmd string
+	var scoped bool
+	if err == nil && !routed {
+		pkgCmd, scoped, err = renderGoPackageCmds(c, rel)
+	}
+	switch {
+	case err != nil:
+	case routed:
+		buildCmd = ruleCmd
+		buildArgv = []string{"bash", "-c", buildCmd}
+	case scoped:
+		buildCmd = pkgCmd
+		buildArgv = []string{"bash", "-c", buildCmd}
+	case len(c.BuildArgv) > 0:
+		buildArgv, err = renderBuildArgv(c, rel)
+		buildCmd = shellJoin(buildArgv)
+	default:
+		buildCmd, err = renderBuildCmd(c, rel)
+		buildArgv = []string{"bash", "-c", buildCmd}
+	}
+	if err != nil {
+		return "", "", nil, fmt.Errorf("Could not render BuildCmd: %v", err)
+	}
+	preCmd, err = renderBuildTemplate(c, "PreBuildCmd", c.PreBuildCmd, rel)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("Could not render PreBuildCmd: %v", err)
+	}
+	return preCmd, buildCmd, buildArgv, nil
+}
+
+// Make the command that runs one command of a build locally, in Docker or on Remote,
+// with its working directory and environment set.
+// killElsewhere stops it where it runs when that isn't in the local process group, or is nil.
+func buildCommand(c Config, buildCmd string, buildArgv []string, rel []string, fileEnv []string) (cmd *exec.Cmd, killElsewhere func(syscall.Signal)) {
+	changedEnv := strings.Join(rel, "\n")
+	switch {
+	case c.Docker != nil:
+		name := nextBuildName()
+		cmd = dockerCommand(c, name, buildArgv, envKeys(fileEnv))
+		killElsewhere = func(sig syscall.Signal) { dockerKill(name, sig) }
+	case c.Remote != nil:
+		name := nextRemoteBuildName()
+		remoteEnv := append([]string(nil), fileEnv...)
+		if c.PassChangedFiles {
+			remoteEnv = append(remoteEnv, "BUILDERATOR_CHANGED_FILES="+changedEnv)
+		}
+		cmd = remoteCommand(c, name, buildCmd, remoteEnv)
+		killElsewhere = func(sig syscall.Signal) { remoteKill(*c.Remote, name, sig) }
+	default:
+		cmd = exec.Command(buildArgv[0], buildArgv[1:]...)
+	}
+	cmd.Dir = c.BuildCmdDir
+	if len(fileEnv) > 0 || c.PassChangedFiles {
+		// Later entries win.
+		cmd.Env = append(os.Environ(), fileEnv...)
+	}
+	if c.PassChangedFiles {
+		cmd.Env = append(cmd.Env, "BUILDERATOR_CHANGED_FILES="+changedEnv)
+	}
+	return cmd, killElsewhere
+}
+
+// Start one command of a build, either BuildCmd as `buildCmd` and `buildArgv`,
+// or PreBuildCmd. `fileEnv` is from BuildEnvFile. `progress` is as for build.
+// A single result is always returned on the channel even when aborted.
+func runBuildCmd(ctx context.Context, c Config, runner CommandRunner, buildCmd string, buildArgv []string, rel []string, fileEnv []string, progress io.Writer) <-chan BuildResult {
+	resultCh := make(chan BuildResult, 1)
+	cmd, killElsewhere := buildCommand(c, buildCmd, buildArgv, rel, fileEnv)
+
+	stdout := newTailBuffer(c.MaxOutputBytes)
+	stderr := newTailBuffer(c.MaxOutputBytes)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	streamOut := &lineStreamer{out: os.Stdout, prefix: STREAM_PREFIX, stream: "stdout"}
+	streamErr := &lineStreamer{out: os.Stdout, prefix: STREAM_PREFIX, stream: "stderr"}
+	if c.StreamOutput && progress != nil {
+		cmd.Stdout = io.MultiWriter(stdout, streamOut, progress)
+		cmd.Stderr = io.MultiWriter(stderr, streamErr, progress)
+	} else if c.StreamOutput {
+		cmd.Stdout = io.MultiWriter(stdout, streamOut)
+		cmd.Stderr = io.MultiWriter(stderr, streamErr)
+	}
+
+	logDebug("build starting in %v: %v", cmd.Dir, buildCmd)
+	start := time.Now()
+	proc, err := runner.Start(cmd)
+	if err != nil {
+		resultCh <- BuildResult{
+			Error: fmt.Errorf("Build failed to start: %v", err),
+		}
+		return resultCh
+	}
+
+	// Closed once the process has exited and all its output is written.
+	exited := make(chan struct{})
+
+	// Killer for aborting
+	go func() {
+		select {
+		case <-ctx.Done():
+			kill(c, proc, killElsewhere, exited)
+		case <-exited:
+		}
+	}()
+
+	// Receiver for completion
+	go func() {
+		exit := proc.Wait()
+		streamOut.Flush()
+		streamErr.Flush()
+		close(exited)
+		logDebug("build exited after %v: %v", time.Since(start), exit)
+		if ctx.Err() != nil {
+			resultCh <- BuildResult{
+				Error:    fmt.Errorf("Build canceled"),
+				Duration: time.Since(start),
+			}
+			return
+		}
+		resultCh <- BuildResult{
+			Error:    exit,
+			Stdout:   stdout.String(),
+			Stderr:   stderr.String(),
+			Duration: time.Since(start),
+		}
+	}()
+
+	return resultCh
+}
+
+// Send KillSignal to a build,
+// or with `killElsewhere` if it runs in a container or on another machine.
+// Escalates to SIGKILL if it hasn't exited after KillGracePeriod.
+func kill(c Config, proc Command, killElsewhere func(syscall.Signal), exited <-chan struct{}) {
+	if proc.Signal(0) != nil {
+		return
+	}
+	if killElsewhere != nil {
+		// Signaling the local client would leave the build running.
+		logDebug("sending %v to build", c.KillSignal)
+		killElsewhere(c.KillSignal)
+	} else {
+		proc.Signal(c.KillSignal)
+	}
+	select {
+	case <-exited:
+		return
+	case <-time.After(c.KillGracePeriod):
+	}
+	logInfo("Build still running %v after signal %d (%v), sending SIGKILL", c.KillGracePeriod, int(c.KillSignal), c.KillSignal)
+	if killElsewhere != nil {
+		killElsewhere(syscall.SIGKILL)
+	}
+	proc.Signal(syscall.SIGKILL)
+}
+
+// Make changed file paths relative to WatchDir.
+// Files outside it, from ExtraWatchPaths, stay absolute.
+func relChanged(c Config, changed []string) []string {
+	var rel []string
+	for _, f := range changed {
+		r, err := filepath.Rel(c.WatchDir, f)
+		if err != nil || r == ".." || strings.HasPrefix(r, "../") {
+			r = f
+		}
+		rel = append(rel, r)
+	}
+	return rel
+}
+
+// Render BuildCmd as a text/template with a BuildCmdContext.
+// Commands without any template actions are returned untouched.
+func renderBuildCmd(c Config, changedRel []string) (string, error) {
+	return renderBuildTemplate(c, "BuildCmd", c.BuildCmd, changedRel)
+}
+
+// Render each argument of BuildArgv as a text/template with a BuildCmdContext.
+func renderBuildArgv(c Config, changedRel []string) ([]string, error) {
+	var argv []string
+	for _, arg := range c.BuildArgv {
+		r, err := renderBuildTemplate(c, "BuildArgv", arg, changedRel)
+		if err != nil {
+			return nil, err
+		}
+		argv = append(argv, r)
+	}
+	return argv, nil
+}
+
+func renderBuildTemplate(c Config, name string, text string, changedRel []string) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+	t, err := template.New(name).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	err = t.Execute(&b, BuildCmdContext{
+		ChangedFiles: changedRel,
+		WatchDir:     c.WatchDir,
+		ConfigPath:   c.ConfigPath,
+	})
+	if err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// Copy the justasec placeholder from PATH over binpath.
+func justasec(binpath string) error {
+	jaspath, err := which("justasec")
+	if err != nil {
+		return err
+	}
+	if jaspath == nil {
+		return fmt.Errorf("could not find 'justasec' in PATH")
+	}
+	cmd := exec.Command("cp", *jaspath, binpath)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v %v", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// which finds the full path of an executable.
+// Similar to `which` in bash but not perfect.
+// Does not ignore files that you don't have permission to execute if anyone does.
+// Skips directories named like the executable.
+// On Windows also tries the extensions in PATHEXT, like name.exe.
+// Fumbles relative paths.
+func which(name string) (*string, error) {
+	candidates := executableNames(name, runtime.GOOS, os.Getenv("PATHEXT"))
+	for _, cand := range candidates {
+		directlyExecutable, err := isExecutable(cand)
+		if err != nil {
+			return nil, err
+		}
+		if directlyExecutable {
+			path, err := filepath.Abs(cand)
+			if err != nil {
+				return nil, err
+			}
+			return &path, nil
+		}
+	}
+	pathDirs := strings.Split(os.Getenv("PATH"), string(os.PathListSeparator))
+	for _, dirPath := range pathDirs {
+		info, err := os.Stat(dirPath)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("error in stat %v: %v", dirPath, err)
+		}
+		if !info.IsDir() {
+			continue
+		}
+		for _, cand := range candidates {
+			path, err := filepath.Abs(filepath.Join(dirPath, cand))
+			if err != nil {
+				return nil, err
+			}
+			executable, err := isExecutable(path)
+			if err != nil {
+				return nil, err
+			}
+			if executable {
+				return &path, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// The file names that run `name` on `goos`.
+// On Windows that is name with each PATHEXT extension, unless it already has one of them.
+func executableNames(name string, goos string, pathext string) []string {
+	if goos != "windows" {
+		return []string{name}
+	}
+	exts := windowsExts(pathext)
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, e := range exts {
+		if ext == e {
+			return []string{name}
+		}
+	}
+	var names []string
+	for _, e := range exts {
+		names = append(names, name+e)
+	}
+	return names
+}
+
+// The executable extensions from a PATHEXT value, lower case.
+func windowsExts(pathext string) []string {
+	if pathext == "" {
+		pathext = ".com;.exe;.bat;.cmd"
+	}
+	var exts []string
+	for _, e := range strings.Split(strings.ToLower(pathext), ";") {
+		if e == "" {
+			continue
+		}
+		if e[0] != '.' {
+			e = "." + e
+		}
+		exts = append(exts, e)
+	}
+	return exts
+}
+
+// Whether path is a file that can be run. Directories never can.
+// On Windows any file is, since which only asks about PATHEXT names.
+func isExecutable(path string) (bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if info.IsDir() {
+		return false, nil
+	}
+	if runtime.GOOS == "windows" {
+		return true, nil
+	}
+	return info.Mode()&0111 != 0, nil
+}
@@ -0,0 +1,52 @@
+package engine
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJustasec(t *testing.T) {
+	dir, err := ioutil.TempDir("", "builderator-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bin := filepath.Join(dir, "bin")
+	err = os.Mkdir(bin, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	placeholder := "#!/bin/sh\necho just a sec\n"
+	err = ioutil.WriteFile(filepath.Join(bin, "justasec"), []byte(placeholder), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := filepath.Join(dir, "target")
+	err = ioutil.WriteFile(target, []byte("old binary"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldPath := os.Getenv("PATH")
+	defer os.Setenv("PATH", oldPath)
+	os.Setenv("PATH", bin+string(os.PathListSeparator)+oldPath)
+
+	err = justasec(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != placeholder {
+		t.Fatalf("target contains %q, expected the placeholder", b)
+	}
+
+	os.Setenv("PATH", dir)
+	if err := justasec(target); err == nil {
+		t.Fatal("expected an error without justasec on PATH")
+	}
+}
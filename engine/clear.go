@@ -0,0 +1,45 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+// CLEAR_SCREEN moves the cursor to the top left and clears the terminal.
+const CLEAR_SCREEN = "\033[H\033[2J"
+
+var stdoutTerminalOnce sync.Once
+var stdoutTerminal bool
+
+// IsTerminal reports whether f is attached to a terminal.
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	// Char devices like /dev/null aren't terminals, only stty can tell.
+	cmd := exec.Command("stty", "-g")
+	cmd.Stdin = f
+	return cmd.Run() == nil
+}
+
+// Whether stdout is a terminal, which clearing only makes sense for.
+func stdoutIsTerminal() bool {
+	stdoutTerminalOnce.Do(func() {
+		stdoutTerminal = IsTerminal(os.Stdout)
+	})
+	return stdoutTerminal
+}
+
+// Clear the terminal before a build if asked to, so only its output is on screen.
+// Never for json logs, which are read by programs.
+func clearScreen(c Config, force bool) {
+	if !(c.ClearScreen || force) || logFormat == LOG_FORMAT_JSON || !stdoutIsTerminal() {
+		return
+	}
+	logMu.Lock()
+	defer logMu.Unlock()
+	fmt.Print(CLEAR_SCREEN)
+}
@@ -0,0 +1,56 @@
+package engine
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// CommandRunner starts the commands builds run.
+// Set Runner.CommandRunner to run builds some other way, like with a FakeCommandRunner in tests.
+type CommandRunner interface {
+	// Start cmd, which is ready to go with its Args, Dir, Env and output writers set.
+	// The command must not write output after Wait returns.
+	Start(cmd *exec.Cmd) (Command, error)
+}
+
+// Command is a build command that has started.
+type Command interface {
+	// Wait blocks until the command exits and returns its error, like exec.Cmd.Wait.
+	Wait() error
+	// Signal sends sig to the command and everything it started.
+	// Signal 0 only checks that it is still running.
+	// Returns an error if it has already exited.
+	Signal(sig syscall.Signal) error
+}
+
+// ExecCommandRunner runs commands as local processes, each in its own process group
+// unless cmd.SysProcAttr says otherwise.
+type ExecCommandRunner struct{}
+
+func (ExecCommandRunner) Start(cmd *exec.Cmd) (Command, error) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	}
+	err := cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	return execCommand{cmd}, nil
+}
+
+type execCommand struct {
+	cmd *exec.Cmd
+}
+
+func (e execCommand) Wait() error {
+	return e.cmd.Wait()
+}
+
+func (e execCommand) Signal(sig syscall.Signal) error {
+	pgid, err := syscall.Getpgid(e.cmd.Process.Pid)
+	if err != nil {
+		return err
+	}
+	logDebug("sending %v to build process group %v", sig, pgid)
+	return syscall.Kill(-pgid, sig)
+}
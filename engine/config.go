@@ -0,0 +1,1648 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/template"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+const (
+	WATCH_BACKEND_AUTO    = "auto"
+	WATCH_BACKEND_FSWATCH = "fswatch"
+	WATCH_BACKEND_POLL    = "poll"
+
+	// The older WatchMode setting, "event" for fswatch or "poll".
+	WATCH_MODE_EVENT = "event"
+	WATCH_MODE_POLL  = "poll"
+
+	DEFAULT_POLL_INTERVAL = time.Second
+
+	DEFAULT_MAX_OUTPUT_BYTES = 1024 * 1024
+
+	DEFAULT_KILL_SIGNAL       = syscall.SIGTERM
+	DEFAULT_KILL_GRACE_PERIOD = 5 * time.Second
+
+	DEFAULT_BUILD_RETRY_DELAY = time.Second
+
+	DEFAULT_HISTORY_SIZE = 20
+
+	STATUS_FORMAT_TEXT = "text"
+	STATUS_FORMAT_JSON = "json"
+
+	DEFAULT_STATUS_FILE_MODE = 0644
+
+	DEFAULT_CONTROL_HOST = "127.0.0.1"
+
+	// Defaults shared by every project, overridden by the project's config.
+	GLOBAL_CONF_PATH = "~/.config/builderator/config.toml"
+)
+
+// rawConfig is the config before validation.
+// All paths are absolute or relative to the config file.
+// Every field is a pointer or interface so that values left unset
+// can be filled in from the global config.
+type rawConfig struct {
+	WatchDir      *string
+	BuildCmd      *string
+	BuildArgv     []string
+	BuildCmdDir   *string
+	StatusFile    *string
+	BuildFile     interface{} // A path or a list of paths.
+	StatusBarPort *int
+
+	AggregateStatusFile *string
+
+	UseJustASec *bool
+
+	HeartbeatFile     *string
+	HeartbeatInterval *duration
+
+	BuildEnvFile *string
+
+	PreBuildCmd *string
+
+	ExtraWatchPaths []string
+
+	PassChangedFiles *bool
+
+	GoPackageScoped *bool
+	GoPackageCmd    *string
+
+	WatchBackend *string
+	WatchMode    *string
+	PollInterval *duration
+
+	// Signal name like "TERM" or number like 15.
+	KillSignal      interface{}
+	KillGracePeriod *duration
+
+	FastRestart *bool
+
+	FinishThenRebuild *bool
+
+	StreamOutput   *bool
+	UsePTY         *bool
+	ClearScreen    *bool
+	MaxOutputBytes *int
+
+	StatusFlushInterval *duration
+
+	StatusFormat   *string
+	StatusFileMode *string
+
+	StatusWriteInterval *duration
+
+	StatusOnlyOutputOnFailure *bool
+
+	TooltipLines *int
+
+	CreateStatusDir *bool
+
+	ControlPort *int
+	ControlHost *string
+
+	MetricsPort *int
+
+	AlwaysReport *bool
+
+	BuildOnStart *bool
+
+	BuildRetries    *int
+	BuildRetryDelay *duration
+
+	StripANSI *bool
+
+	StatusBarTitles    *bool
+	StatusBarProto     *string
+	StatusBarKeepAlive *bool
+	StatusBarTimeout   *duration
+	InitBuildColor     *string
+	// What the status bar shows for each state, keyed by state.
+	StatusBarColors       map[string]string
+	StatusBarStrictColors *bool
+
+	HistorySize *int
+
+	OnFailureSound *string
+
+	SuppressFirstNotification *bool
+
+	EscalateAfterFailures *int
+	NotifyAfter           *duration
+	OnStatusChange        *string
+	OnSuccessCmd          *string
+
+	DebounceMode   *string
+	DebounceWindow *duration
+
+	PostBuildCooldown *duration
+
+	MaxBuildsPerMinute *int
+	MinBuildInterval   *duration
+
+	// BuildCmd for some OSes, keyed by GOOS.
+	BuildCmdByOS map[string]string
+
+	Docker *rawDockerConfig
+	Remote *rawRemoteConfig
+
+	Rule []rawRule
+
+	Task []rawTask
+}
+
+// rawTask is one [[Task]] table: a named config over the file's top level values.
+type rawTask struct {
+	Name *string
+	rawConfig
+}
+
+// Make the paths in a raw config absolute, relative to dir.
+func (rc *rawConfig) reroot(dir string) error {
+	for i, p := range rc.ExtraWatchPaths {
+		abs, err := RerootPath(p, dir)
+		if err != nil {
+			return err
+		}
+		rc.ExtraWatchPaths[i] = abs
+	}
+	if files, err := parseBuildFiles(rc.BuildFile); err == nil && files != nil {
+		for i, p := range files {
+			abs, err := RerootPath(p, dir)
+			if err != nil {
+				return err
+			}
+			files[i] = abs
+		}
+		rc.BuildFile = files
+	}
+	for _, p := range []*string{rc.WatchDir, rc.BuildCmdDir, rc.StatusFile, rc.AggregateStatusFile, rc.HeartbeatFile, rc.BuildEnvFile} {
+		if p == nil {
+			continue
+		}
+		if _, ok := expandWatchDir(*p, ""); ok {
+			// Resolved against the final WatchDir when validating.
+			continue
+		}
+		abs, err := RerootPath(*p, dir)
+		if err != nil {
+			return err
+		}
+		*p = abs
+	}
+	return nil
+}
+
+// Overlay the values set in local onto base.
+// BuildCmd and BuildArgv replace each other, and BuildCmdByOS along with them.
+func mergeRawConfig(base rawConfig, local rawConfig) rawConfig {
+	if local.BuildCmd != nil || local.BuildArgv != nil {
+		base.BuildCmd = nil
+		base.BuildArgv = nil
+		base.BuildCmdByOS = nil
+	}
+	if local.WatchBackend != nil || local.WatchMode != nil {
+		base.WatchBackend = nil
+		base.WatchMode = nil
+	}
+	b := reflect.ValueOf(&base).Elem()
+	l := reflect.ValueOf(local)
+	for i := 0; i < l.NumField(); i++ {
+		if !l.Field(i).IsNil() {
+			b.Field(i).Set(l.Field(i))
+		}
+	}
+	return base
+}
+
+// Config is the validated config. All paths are absolute.
+// See example.toml for config specs.
+type Config struct {
+	// Absolute path to the config file.
+	ConfigPath string
+	// Absolute path to the global config merged under it, or "" if there was none.
+	GlobalConfigPath string
+	// Name of the [[Task]] this config is, or "" for a config without tasks.
+	Task string
+
+	WatchDir string
+	BuildCmd string
+	// The key of BuildCmdByOS that BuildCmd came from, or "" for the top level BuildCmd.
+	BuildCmdOS string
+	// Run directly instead of BuildCmd, without a shell. Empty to use BuildCmd.
+	BuildArgv     []string
+	BuildCmdDir   string
+	StatusFile    *string
+	BuildFiles    []string // Binaries to replace with justasec before each build.
+	StatusBarPort int
+
+	// File to write the combined state of all the tasks run together to.
+	AggregateStatusFile *string
+
+	// Copy justasec over BuildFiles before each build.
+	UseJustASec bool
+
+	// Dotenv file of variables to add to BuildCmd's environment, read before each build.
+	BuildEnvFile *string
+
+	// Run with bash before BuildCmd in each build, like fetching dependencies.
+	// The state is STATE_WARMING while it runs. If it fails so does the build. "" for none.
+	PreBuildCmd string
+
+	// More directories or files whose changes trigger a build, like dependencies outside WatchDir.
+	ExtraWatchPaths []string
+	// Patterns from the IGNORE_FILE_NAME next to the config file for changes that don't
+	// trigger a build. nil if there is no such file.
+	Ignore *IgnoreRules
+	// Files builderator writes itself inside WatchDir or ExtraWatchPaths, like StatusFile,
+	// which never trigger a build so that writing them doesn't rebuild forever.
+	WatchExcludes []string
+
+	// File to write the time to every HeartbeatInterval while running. nil disables it.
+	HeartbeatFile     *string
+	HeartbeatInterval time.Duration
+
+	// Expose the changed files to BuildCmd as BUILDERATOR_CHANGED_FILES.
+	PassChangedFiles bool
+
+	// Run GoPackageCmd for each Go package with changed files instead of BuildCmd.
+	GoPackageScoped bool
+	// Run for each changed package with GoPackageScoped.
+	// May use the same template actions as BuildCmd, plus {{.Package}}.
+	GoPackageCmd string
+
+	// How to detect changes: WATCH_BACKEND_FSWATCH or WATCH_BACKEND_POLL.
+	WatchBackend string
+	// WatchBackend was picked by WATCH_BACKEND_AUTO rather than set.
+	WatchBackendAuto bool
+	// How often to walk WatchDir in poll mode.
+	PollInterval time.Duration
+
+	// Signal sent to the build's process group to abort it.
+	KillSignal syscall.Signal
+	// How long to wait after KillSignal before sending SIGKILL.
+	KillGracePeriod time.Duration
+
+	// Start the next build without waiting for the canceled one to exit.
+	FastRestart bool
+	// Let a build finish when files change, then build again if any did, instead of aborting it.
+	FinishThenRebuild bool
+
+	// Print build output live as it arrives.
+	StreamOutput bool
+	// With StreamOutput, how often to write the output so far to StatusFile during a build. 0 never does.
+	StatusFlushInterval time.Duration
+	// Clear the terminal before each build when stdout is one.
+	ClearScreen bool
+	// Run builds attached to a pseudo-terminal so they print as if interactive.
+	// Their stderr is then part of stdout.
+	UsePTY bool
+	// How much of the end of each of stdout and stderr to keep.
+	MaxOutputBytes int
+
+	// How to write StatusFile: STATUS_FORMAT_TEXT or STATUS_FORMAT_JSON.
+	StatusFormat string
+	// Permissions of StatusFile.
+	StatusFileMode os.FileMode
+	// Write StatusFile at most this often, writing the latest state once it's up. 0 for no limit.
+	StatusWriteInterval time.Duration
+	// Leave the output of successful builds out of StatusFile.
+	StatusOnlyOutputOnFailure bool
+	// How many of the last lines of output to record for tooltips, 0 for none.
+	// They go in the "tail" field of a json StatusFile, or next to a text one in TooltipFile.
+	TooltipLines int
+	// Create StatusFile's directory when loading the config if it is missing.
+	CreateStatusDir bool
+
+	// TCP port for the HTTP control server. 0 disables it.
+	ControlPort int
+	ControlHost string
+
+	// TCP port for the Prometheus metrics server on ControlHost. 0 disables it.
+	MetricsPort int
+
+	// Notify of every build's outcome, even a success after a success,
+	// and send the status bar every color even if it already shows it.
+	AlwaysReport bool
+
+	// Build as soon as Run starts rather than waiting for the first change.
+	BuildOnStart bool
+
+	// How many times to rerun a failed build before reporting the failure.
+	BuildRetries int
+	// How long to wait before each retry.
+	BuildRetryDelay time.Duration
+
+	// Remove terminal escape sequences like colors from output in StatusFile.
+	StripANSI bool
+
+	// Show the last build's outcome as the status bar title.
+	StatusBarTitles bool
+	// STATUS_BAR_PROTO_UDP for AnyBar or STATUS_BAR_PROTO_TCP.
+	StatusBarProto string
+	// Over tcp, retry the latest color with backoff until it's delivered.
+	StatusBarKeepAlive bool
+	// How long each send to the status bar may take.
+	StatusBarTimeout time.Duration
+	// Status bar color to show at startup, before anything is built.
+	InitBuildColor string
+	// What the status bar shows for each state: an AnyBar color or the name of a custom image.
+	// Has every state. nil in a Config built by hand means the AnyBar defaults.
+	StatusBarColors map[string]string
+	// Colors must be one of StatusBarColors rather than custom images.
+	StatusBarStrictColors bool
+
+	// How many finished builds to remember for GET /history.
+	HistorySize int
+
+	// Absolute path to an audio file played when a build fails, FAILURE_SOUND_BELL
+	// to ring the terminal bell, or "" for silence.
+	OnFailureSound string
+	// Treat the first build after starting as the baseline and don't play OnFailureSound for it.
+	SuppressFirstNotification bool
+
+	// After this many failed builds in a row show StatusBarExclamation
+	// and play OnFailureSound on every failure. 0 disables it.
+	EscalateAfterFailures int
+	// Only play OnFailureSound once builds have been failing for this long. 0 plays it right away.
+	NotifyAfter time.Duration
+	// Command run in the background whenever the state changes, with BUILDERATOR_STATE set.
+	// May use text/template actions with an OnStatusChangeContext.
+	OnStatusChange string
+	// Command run in the background when a build succeeds after one that didn't,
+	// like a deploy that shouldn't repeat on every success.
+	OnSuccessCmd string
+
+	// DEBOUNCE_LEADING or DEBOUNCE_TRAILING.
+	DebounceMode string
+	// How long changes must stop for, or how long to ignore them after a build starts.
+	// 0 builds on every batch of changes.
+	DebounceWindow time.Duration
+
+	// How long to ignore changes after a build finishes, for builds that write into WatchDir.
+	PostBuildCooldown time.Duration
+
+	// Start at most this many builds a minute. 0 is no limit.
+	MaxBuildsPerMinute int
+	// Start each build at least this long after the last one started. 0 is no limit.
+	MinBuildInterval time.Duration
+
+	// Run builds in a container instead of locally. nil runs locally.
+	Docker *DockerConfig
+	// Copy WatchDir to another machine and build there. nil builds locally.
+	Remote *RemoteConfig
+
+	// Commands to run instead of BuildCmd for the changed files they match.
+	Rules []Rule
+
+	// Problems that leave the config usable, for whoever reads it to report.
+	Warnings ConfigErrors
+}
+
+// BuildCmdString is the build command for display, BuildCmd or BuildArgv quoted for bash.
+func (c Config) BuildCmdString() string {
+	if len(c.BuildArgv) > 0 {
+		return shellJoin(c.BuildArgv)
+	}
+	return c.BuildCmd
+}
+
+// duration is a time.Duration that decodes from strings like "1.5s".
+type duration struct {
+	time.Duration
+}
+
+func (d *duration) UnmarshalText(text []byte) error {
+	var err error
+	d.Duration, err = time.ParseDuration(string(text))
+	return err
+}
+
+// ConfigErrors is every problem found in a config.
+type ConfigErrors []error
+
+func (e ConfigErrors) Error() string {
+	var msgs []string
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// ReadConfig reads and validates the config file at the absolute path cpath.
+// Problems with individual values are all collected into a ConfigErrors.
+// A WatchDir or BuildCmdDir that doesn't exist is reported as a DirError.
+// Values the file leaves unset are taken from GLOBAL_CONF_PATH if it exists.
+// Configs with [[Task]] tables must be read with ReadTasks.
+func ReadConfig(cpath string) (Config, error) {
+	rc, gpath, err := decodeConfig(cpath)
+	if err != nil {
+		return Config{}, err
+	}
+	if len(rc.Task) > 0 {
+		return Config{}, fmt.Errorf("%v defines tasks, expected a single config", cpath)
+	}
+	return validateConfig(rc, cpath, gpath)
+}
+
+// ReadTasks reads and validates every task in the config file at the absolute path cpath.
+// Each [[Task]] table is merged over the file's top level values, which are merged
+// over GLOBAL_CONF_PATH. A config without [[Task]] tables is a single task with no name.
+// The configs are returned even if some are invalid, as ReadConfig does.
+// Problems in a task are reported as TaskErrors in a ConfigErrors.
+func ReadTasks(cpath string) ([]Config, error) {
+	rc, gpath, err := decodeConfig(cpath)
+	if err != nil {
+		return nil, err
+	}
+	if len(rc.Task) == 0 {
+		c, err := validateConfig(rc, cpath, gpath)
+		return []Config{c}, err
+	}
+
+	var cs []Config
+	var errs ConfigErrors
+	tasks := rc.Task
+	rc.Task = nil
+	seen := make(map[string]bool)
+	for i, t := range tasks {
+		if t.Name == nil || *t.Name == "" {
+			errs = append(errs, fmt.Errorf("missing required config value: Name of task %v", i+1))
+			continue
+		}
+		name := *t.Name
+		if seen[name] {
+			errs = append(errs, fmt.Errorf("duplicate task name %q", name))
+			continue
+		}
+		seen[name] = true
+		if len(t.Task) > 0 {
+			errs = append(errs, TaskError{Task: name, Err: fmt.Errorf("tasks can't have tasks")})
+			continue
+		}
+		c, err := validateConfig(mergeRawConfig(rc, t.rawConfig), cpath, gpath)
+		c.Task = name
+		switch err := err.(type) {
+		case nil:
+		case ConfigErrors:
+			for _, err := range err {
+				errs = append(errs, TaskError{Task: name, Err: err})
+			}
+		default:
+			errs = append(errs, TaskError{Task: name, Err: err})
+		}
+		cs = append(cs, c)
+	}
+	errs = append(errs, checkTaskPorts(cs)...)
+	if len(errs) > 0 {
+		return cs, errs
+	}
+	return cs, nil
+}
+
+// ReadTask reads the task named `name` from the config file at cpath.
+// An empty name reads a config without tasks.
+func ReadTask(cpath string, name string) (Config, error) {
+	if name == "" {
+		return ReadConfig(cpath)
+	}
+	cs, err := ReadTasks(cpath)
+	for _, c := range cs {
+		if c.Task != name {
+			continue
+		}
+		// Only this task's problems matter.
+		if errs, ok := err.(ConfigErrors); ok {
+			var mine ConfigErrors
+			for _, err := range errs {
+				if te, ok := err.(TaskError); ok && te.Task == name {
+					mine = append(mine, te.Err)
+				}
+			}
+			if len(mine) == 0 {
+				return c, nil
+			}
+			return c, mine
+		}
+		return c, err
+	}
+	if err != nil {
+		return Config{}, err
+	}
+	return Config{}, fmt.Errorf("no task named %q in %v", name, cpath)
+}
+
+// Tasks listening on the same port would fight over it.
+func checkTaskPorts(cs []Config) ConfigErrors {
+	var errs ConfigErrors
+	check := func(name string, port func(Config) int) {
+		ports := make(map[int]string)
+		for _, c := range cs {
+			p := port(c)
+			if p == 0 {
+				continue
+			}
+			if other, ok := ports[p]; ok {
+				errs = append(errs, fmt.Errorf("tasks %q and %q both use %v %v", other, c.Task, name, p))
+				continue
+			}
+			ports[p] = c.Task
+		}
+	}
+	check("ControlPort", func(c Config) int { return c.ControlPort })
+	check("MetricsPort", func(c Config) int { return c.MetricsPort })
+	return errs
+}
+
+// TaskError is a problem with one task in a config.
+type TaskError struct {
+	Task string
+	Err  error
+}
+
+func (e TaskError) Error() string {
+	return fmt.Sprintf("task %v: %v", e.Task, e.Err)
+}
+
+// Decode the config file at cpath merged over the global config.
+// Returns the path of the global config, or "" if there was none.
+func decodeConfig(cpath string) (rawConfig, string, error) {
+	var rc rawConfig
+	if !path.IsAbs(cpath) {
+		return rc, "", fmt.Errorf("config path must be absolute: %v", cpath)
+	}
+
+	_, err := toml.DecodeFile(cpath, &rc)
+	if err != nil {
+		return rc, "", err
+	}
+	rc.expandEnv()
+
+	gpath, err := Homeopathy(GLOBAL_CONF_PATH)
+	if err == nil && gpath != path.Clean(cpath) {
+		grc, err := readGlobalConfig(gpath)
+		if err != nil {
+			return rc, "", fmt.Errorf("global config %v: %v", gpath, err)
+		}
+		if grc != nil {
+			// Tasks only come from the project's config.
+			grc.Task = nil
+			return mergeRawConfig(*grc, rc), gpath, nil
+		}
+	}
+	return rc, "", nil
+}
+
+// The GOOS values BuildCmdByOS may be keyed by, to catch typos.
+var knownGOOS = []string{
+	"aix", "android", "darwin", "dragonfly", "freebsd", "illumos", "ios", "js",
+	"linux", "netbsd", "openbsd", "plan9", "solaris", "wasip1", "windows",
+}
+
+// Replace BuildCmd and BuildArgv with the BuildCmdByOS entry for goos, if there is one.
+// Returns the key used, or "".
+func resolveBuildCmdByOS(rc *rawConfig, goos string) (string, error) {
+	for k := range rc.BuildCmdByOS {
+		known := false
+		for _, g := range knownGOOS {
+			if k == g {
+				known = true
+			}
+		}
+		if !known {
+			return "", fmt.Errorf("invalid BuildCmdByOS: unknown GOOS %q", k)
+		}
+	}
+	cmd, ok := rc.BuildCmdByOS[goos]
+	if !ok {
+		return "", nil
+	}
+	rc.BuildCmd = &cmd
+	rc.BuildArgv = nil
+	return goos, nil
+}
+
+// Pick the watcher from WatchBackend, or the older WatchMode.
+// WATCH_BACKEND_AUTO, the default, uses fswatch if it is in PATH and polls otherwise.
+// Returns the backend and whether auto picked it.
+func resolveWatchBackend(backend *string, mode *string) (string, bool, error) {
+	var b string
+	switch {
+	case backend != nil && mode != nil:
+		return "", false, fmt.Errorf("use one of WatchBackend and WatchMode, not both")
+	case backend != nil:
+		b = *backend
+	case mode == nil:
+		b = WATCH_BACKEND_AUTO
+	case *mode == WATCH_MODE_EVENT:
+		b = WATCH_BACKEND_FSWATCH
+	case *mode == WATCH_MODE_POLL:
+		b = WATCH_BACKEND_POLL
+	default:
+		return "", false, fmt.Errorf("invalid WatchMode %q: must be %q or %q", *mode, WATCH_MODE_EVENT, WATCH_MODE_POLL)
+	}
+	switch b {
+	case WATCH_BACKEND_FSWATCH, WATCH_BACKEND_POLL:
+		return b, false, nil
+	case WATCH_BACKEND_AUTO:
+		if bin, err := which("fswatch"); err == nil && bin != nil {
+			return WATCH_BACKEND_FSWATCH, true, nil
+		}
+		return WATCH_BACKEND_POLL, true, nil
+	default:
+		return "", false, fmt.Errorf("invalid WatchBackend %q: must be %q, %q or %q", b, WATCH_BACKEND_AUTO, WATCH_BACKEND_FSWATCH, WATCH_BACKEND_POLL)
+	}
+}
+
+// Validate a decoded config read from cpath.
+// `gpath` is the global config merged under it, or "".
+func validateConfig(rc rawConfig, cpath string, gpath string) (Config, error) {
+	var c Config
+	var err error
+	c.ConfigPath = path.Clean(cpath)
+	c.GlobalConfigPath = gpath
+	confdir := path.Dir(c.ConfigPath)
+	var errs ConfigErrors
+	var warns ConfigErrors
+
+	if rc.WatchDir == nil {
+		errs = append(errs, fmt.Errorf("missing required config value: WatchDir"))
+	} else {
+		c.WatchDir, err = RerootPath(*rc.WatchDir, confdir)
+		if err != nil {
+			errs = append(errs, err)
+		} else if err := checkDir("WatchDir", c.WatchDir); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	c.Ignore, err = readIgnoreFile(path.Join(confdir, IGNORE_FILE_NAME))
+	if err != nil {
+		errs = append(errs, fmt.Errorf("invalid %v: %v", IGNORE_FILE_NAME, err))
+	}
+
+	c.BuildCmdOS, err = resolveBuildCmdByOS(&rc, runtime.GOOS)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	switch {
+	case rc.BuildCmd != nil && rc.BuildArgv != nil:
+		errs = append(errs, fmt.Errorf("use one of BuildCmd and BuildArgv, not both"))
+	case rc.BuildArgv != nil:
+		if len(rc.BuildArgv) == 0 || rc.BuildArgv[0] == "" {
+			errs = append(errs, fmt.Errorf("invalid BuildArgv: must start with a program"))
+		}
+		c.BuildArgv = rc.BuildArgv
+	case rc.BuildCmd != nil:
+		c.BuildCmd = *rc.BuildCmd
+	default:
+		errs = append(errs, fmt.Errorf("missing required config value: BuildCmd (or BuildArgv)"))
+	}
+
+	c.BuildCmdDir = confdir
+	if rc.BuildCmdDir != nil {
+		p, _ := expandWatchDir(*rc.BuildCmdDir, c.WatchDir)
+		c.BuildCmdDir, err = RerootPath(p, confdir)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := checkDir("BuildCmdDir", c.BuildCmdDir); err != nil {
+		errs = append(errs, err)
+	}
+
+	if rc.CreateStatusDir != nil {
+		c.CreateStatusDir = *rc.CreateStatusDir
+	}
+	if rc.StatusFile != nil {
+		s, err := RerootPath(*rc.StatusFile, confdir)
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			c.StatusFile = &s
+			if err := checkStatusDir(path.Dir(s), c.CreateStatusDir); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	buildFiles, err := parseBuildFiles(rc.BuildFile)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("invalid BuildFile: %v", err))
+	}
+	for _, p := range buildFiles {
+		s, err := RerootPath(p, confdir)
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			c.BuildFiles = append(c.BuildFiles, s)
+		}
+	}
+
+	c.UseJustASec = len(c.BuildFiles) > 0
+	if rc.UseJustASec != nil {
+		c.UseJustASec = *rc.UseJustASec
+	}
+	if c.UseJustASec && len(buildFiles) == 0 {
+		errs = append(errs, fmt.Errorf("UseJustASec needs a BuildFile to replace"))
+	}
+
+	if rc.AggregateStatusFile != nil {
+		s, err := RerootPath(*rc.AggregateStatusFile, confdir)
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			c.AggregateStatusFile = &s
+		}
+	}
+
+	for _, p := range rc.ExtraWatchPaths {
+		abs, err := RerootPath(p, confdir)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if _, err := os.Stat(abs); err != nil {
+			errs = append(errs, fmt.Errorf("invalid ExtraWatchPaths entry: %v", err))
+			continue
+		}
+		c.ExtraWatchPaths = append(c.ExtraWatchPaths, abs)
+	}
+
+	if rc.BuildEnvFile != nil {
+		s, err := RerootPath(*rc.BuildEnvFile, confdir)
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			c.BuildEnvFile = &s
+		}
+	}
+
+	if rc.HeartbeatFile != nil {
+		s, err := RerootPath(*rc.HeartbeatFile, confdir)
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			c.HeartbeatFile = &s
+		}
+	}
+	c.HeartbeatInterval = DEFAULT_HEARTBEAT_INTERVAL
+	if rc.HeartbeatInterval != nil {
+		c.HeartbeatInterval = rc.HeartbeatInterval.Duration
+	}
+	if c.HeartbeatInterval <= 0 {
+		errs = append(errs, fmt.Errorf("invalid HeartbeatInterval %v: must be positive", c.HeartbeatInterval))
+	}
+
+	if rc.StatusBarPort != nil {
+		c.StatusBarPort = *rc.StatusBarPort
+	}
+	err = checkPort("StatusBarPort", c.StatusBarPort)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	if rc.ControlPort != nil {
+		c.ControlPort = *rc.ControlPort
+	}
+	err = checkPort("ControlPort", c.ControlPort)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	c.ControlHost = DEFAULT_CONTROL_HOST
+	if rc.ControlHost != nil {
+		c.ControlHost = *rc.ControlHost
+	}
+
+	if rc.MetricsPort != nil {
+		c.MetricsPort = *rc.MetricsPort
+	}
+	err = checkPort("MetricsPort", c.MetricsPort)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if c.MetricsPort > 0 && c.MetricsPort == c.ControlPort {
+		errs = append(errs, fmt.Errorf("MetricsPort and ControlPort are both %v", c.MetricsPort))
+	}
+	if rc.PassChangedFiles != nil {
+		c.PassChangedFiles = *rc.PassChangedFiles
+	}
+
+	if rc.GoPackageScoped != nil {
+		c.GoPackageScoped = *rc.GoPackageScoped
+	}
+	c.GoPackageCmd = DEFAULT_GO_PACKAGE_CMD
+	if rc.GoPackageCmd != nil {
+		c.GoPackageCmd = *rc.GoPackageCmd
+		if !c.GoPackageScoped {
+			errs = append(errs, fmt.Errorf("GoPackageCmd needs GoPackageScoped = true"))
+		}
+	}
+	if _, err := template.New("GoPackageCmd").Parse(c.GoPackageCmd); err != nil {
+		errs = append(errs, fmt.Errorf("invalid GoPackageCmd: %v", err))
+	}
+
+	if rc.StreamOutput != nil {
+		c.StreamOutput = *rc.StreamOutput
+	}
+	if rc.StatusFlushInterval != nil {
+		c.StatusFlushInterval = rc.StatusFlushInterval.Duration
+	}
+	switch {
+	case c.StatusFlushInterval < 0:
+		errs = append(errs, fmt.Errorf("invalid StatusFlushInterval %v: must not be negative", c.StatusFlushInterval))
+	case c.StatusFlushInterval > 0 && !c.StreamOutput:
+		errs = append(errs, fmt.Errorf("StatusFlushInterval needs StreamOutput = true"))
+	case c.StatusFlushInterval > 0 && c.StatusFile == nil:
+		errs = append(errs, fmt.Errorf("StatusFlushInterval needs a StatusFile to write to"))
+	}
+	if rc.ClearScreen != nil {
+		c.ClearScreen = *rc.ClearScreen
+	}
+	if rc.UsePTY != nil {
+		c.UsePTY = *rc.UsePTY
+	}
+
+	if rc.AlwaysReport != nil {
+		c.AlwaysReport = *rc.AlwaysReport
+	}
+
+	c.BuildOnStart = true
+	if rc.BuildOnStart != nil {
+		c.BuildOnStart = *rc.BuildOnStart
+	}
+
+	if rc.BuildRetries != nil {
+		c.BuildRetries = *rc.BuildRetries
+	}
+	if c.BuildRetries < 0 {
+		errs = append(errs, fmt.Errorf("invalid BuildRetries %v: must not be negative", c.BuildRetries))
+	}
+	c.BuildRetryDelay = DEFAULT_BUILD_RETRY_DELAY
+	if rc.BuildRetryDelay != nil {
+		c.BuildRetryDelay = rc.BuildRetryDelay.Duration
+	}
+	if c.BuildRetryDelay < 0 {
+		errs = append(errs, fmt.Errorf("invalid BuildRetryDelay %v: must not be negative", c.BuildRetryDelay))
+	}
+
+	c.StripANSI = true
+	if rc.StripANSI != nil {
+		c.StripANSI = *rc.StripANSI
+	}
+
+	if rc.StatusBarTitles != nil {
+		c.StatusBarTitles = *rc.StatusBarTitles
+	}
+
+	c.StatusBarProto = STATUS_BAR_PROTO_UDP
+	if rc.StatusBarProto != nil {
+		c.StatusBarProto = *rc.StatusBarProto
+	}
+	switch c.StatusBarProto {
+	case STATUS_BAR_PROTO_UDP, STATUS_BAR_PROTO_TCP:
+	default:
+		errs = append(errs, fmt.Errorf("invalid StatusBarProto %q: must be %q or %q", c.StatusBarProto, STATUS_BAR_PROTO_UDP, STATUS_BAR_PROTO_TCP))
+	}
+	if rc.StatusBarKeepAlive != nil {
+		c.StatusBarKeepAlive = *rc.StatusBarKeepAlive
+	}
+	if c.StatusBarKeepAlive && c.StatusBarProto != STATUS_BAR_PROTO_TCP {
+		errs = append(errs, fmt.Errorf("StatusBarKeepAlive needs StatusBarProto = %q", STATUS_BAR_PROTO_TCP))
+	}
+	c.StatusBarTimeout = DEFAULT_STATUS_BAR_TIMEOUT
+	if rc.StatusBarTimeout != nil {
+		c.StatusBarTimeout = rc.StatusBarTimeout.Duration
+	}
+	if c.StatusBarTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("invalid StatusBarTimeout %v: must be positive", c.StatusBarTimeout))
+	}
+
+	if rc.StatusBarStrictColors != nil {
+		c.StatusBarStrictColors = *rc.StatusBarStrictColors
+	}
+	// Anything else is taken as a custom AnyBar image, with a warning in case it's a typo.
+	checkColor := func(name string, color string) {
+		if isStatusBarColor(color) {
+			return
+		}
+		if c.StatusBarStrictColors {
+			errs = append(errs, fmt.Errorf("invalid %v %q: must be one of %v", name, color, strings.Join(StatusBarColors, ", ")))
+		} else {
+			warns = append(warns, fmt.Errorf("%v %q is not an AnyBar color, it must be the name of a custom image", name, color))
+		}
+	}
+	c.StatusBarColors = make(map[string]string)
+	for state, color := range stateStatusBarColors {
+		c.StatusBarColors[state] = color
+	}
+	for state, color := range rc.StatusBarColors {
+		if _, ok := stateStatusBarColors[state]; !ok {
+			errs = append(errs, fmt.Errorf("invalid StatusBarColors: unknown state %q, must be one of %v", state, strings.Join(statusStates, ", ")))
+			continue
+		}
+		checkColor("StatusBarColors."+state, color)
+		c.StatusBarColors[state] = color
+	}
+
+	// Blue says a build is on its way, white that it's waiting for a change.
+	c.InitBuildColor = c.StatusBarColors[STATE_IDLE]
+	if c.BuildOnStart {
+		c.InitBuildColor = c.StatusBarColors[STATE_BUILDING]
+	}
+	if rc.InitBuildColor != nil {
+		c.InitBuildColor = *rc.InitBuildColor
+		checkColor("InitBuildColor", c.InitBuildColor)
+	}
+
+	rules, ruleErrs := readRules(rc.Rule)
+	c.Rules = rules
+	errs = append(errs, ruleErrs...)
+
+	if rc.Docker != nil {
+		d, err := readDockerConfig(*rc.Docker, confdir)
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			c.Docker = d
+		}
+	}
+
+	if rc.Remote != nil {
+		r, err := readRemoteConfig(*rc.Remote)
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			c.Remote = r
+		}
+	}
+	if c.Docker != nil && c.Remote != nil {
+		errs = append(errs, fmt.Errorf("use one of Docker and Remote, not both"))
+	}
+
+	c.HistorySize = DEFAULT_HISTORY_SIZE
+	if rc.HistorySize != nil {
+		c.HistorySize = *rc.HistorySize
+	}
+	if c.HistorySize < 0 {
+		errs = append(errs, fmt.Errorf("invalid HistorySize %v: must not be negative", c.HistorySize))
+	}
+
+	if rc.OnFailureSound != nil && *rc.OnFailureSound != "" {
+		c.OnFailureSound = *rc.OnFailureSound
+		if c.OnFailureSound != FAILURE_SOUND_BELL {
+			c.OnFailureSound, err = RerootPath(c.OnFailureSound, confdir)
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	c.SuppressFirstNotification = true
+	if rc.SuppressFirstNotification != nil {
+		c.SuppressFirstNotification = *rc.SuppressFirstNotification
+	}
+
+	if rc.EscalateAfterFailures != nil {
+		c.EscalateAfterFailures = *rc.EscalateAfterFailures
+	}
+	if c.EscalateAfterFailures < 0 {
+		errs = append(errs, fmt.Errorf("invalid EscalateAfterFailures %v: must not be negative", c.EscalateAfterFailures))
+	}
+	if rc.NotifyAfter != nil {
+		c.NotifyAfter = rc.NotifyAfter.Duration
+	}
+	if c.NotifyAfter < 0 {
+		errs = append(errs, fmt.Errorf("invalid NotifyAfter %v: must not be negative", c.NotifyAfter))
+	}
+
+	if rc.OnStatusChange != nil {
+		c.OnStatusChange = *rc.OnStatusChange
+		if strings.Contains(c.OnStatusChange, "{{") {
+			_, err := template.New("OnStatusChange").Parse(c.OnStatusChange)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid OnStatusChange: %v", err))
+			}
+		}
+	}
+
+	if rc.PreBuildCmd != nil {
+		c.PreBuildCmd = *rc.PreBuildCmd
+		if strings.Contains(c.PreBuildCmd, "{{") {
+			_, err := template.New("PreBuildCmd").Parse(c.PreBuildCmd)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid PreBuildCmd: %v", err))
+			}
+		}
+	}
+
+	if rc.OnSuccessCmd != nil {
+		c.OnSuccessCmd = *rc.OnSuccessCmd
+	}
+
+	c.MaxOutputBytes = DEFAULT_MAX_OUTPUT_BYTES
+	if rc.MaxOutputBytes != nil {
+		c.MaxOutputBytes = *rc.MaxOutputBytes
+	}
+	if c.MaxOutputBytes <= 0 {
+		errs = append(errs, fmt.Errorf("invalid MaxOutputBytes %v: must be positive", c.MaxOutputBytes))
+	}
+
+	c.StatusFormat = STATUS_FORMAT_TEXT
+	if rc.StatusFormat != nil {
+		c.StatusFormat = *rc.StatusFormat
+	}
+	switch c.StatusFormat {
+	case STATUS_FORMAT_TEXT, STATUS_FORMAT_JSON:
+	default:
+		errs = append(errs, fmt.Errorf("invalid StatusFormat %q: must be %q or %q", c.StatusFormat, STATUS_FORMAT_TEXT, STATUS_FORMAT_JSON))
+	}
+
+	if rc.StatusOnlyOutputOnFailure != nil {
+		c.StatusOnlyOutputOnFailure = *rc.StatusOnlyOutputOnFailure
+	}
+
+	if rc.TooltipLines != nil {
+		c.TooltipLines = *rc.TooltipLines
+	}
+	if c.TooltipLines < 0 {
+		errs = append(errs, fmt.Errorf("invalid TooltipLines %v: must not be negative", c.TooltipLines))
+	}
+	if c.TooltipLines > 0 && c.StatusFile == nil {
+		errs = append(errs, fmt.Errorf("TooltipLines needs a StatusFile to write to"))
+	}
+
+	c.StatusFileMode = DEFAULT_STATUS_FILE_MODE
+	if rc.StatusFileMode != nil {
+		c.StatusFileMode, err = ParseFileMode(*rc.StatusFileMode)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid StatusFileMode: %v", err))
+		}
+	}
+
+	if rc.StatusWriteInterval != nil {
+		c.StatusWriteInterval = rc.StatusWriteInterval.Duration
+	}
+	if c.StatusWriteInterval < 0 {
+		errs = append(errs, fmt.Errorf("invalid StatusWriteInterval %v: must not be negative", c.StatusWriteInterval))
+	}
+
+	c.WatchBackend, c.WatchBackendAuto, err = resolveWatchBackend(rc.WatchBackend, rc.WatchMode)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	c.PollInterval = DEFAULT_POLL_INTERVAL
+	if rc.PollInterval != nil {
+		c.PollInterval = rc.PollInterval.Duration
+	}
+	if c.PollInterval <= 0 {
+		errs = append(errs, fmt.Errorf("invalid PollInterval %v: must be positive", c.PollInterval))
+	}
+
+	c.DebounceMode = DEBOUNCE_TRAILING
+	if rc.DebounceMode != nil {
+		c.DebounceMode = *rc.DebounceMode
+	}
+	switch c.DebounceMode {
+	case DEBOUNCE_LEADING, DEBOUNCE_TRAILING:
+	default:
+		errs = append(errs, fmt.Errorf("invalid DebounceMode %q: must be %q or %q", c.DebounceMode, DEBOUNCE_LEADING, DEBOUNCE_TRAILING))
+	}
+	if rc.DebounceWindow != nil {
+		c.DebounceWindow = rc.DebounceWindow.Duration
+	}
+	if c.DebounceWindow < 0 {
+		errs = append(errs, fmt.Errorf("invalid DebounceWindow %v: must not be negative", c.DebounceWindow))
+	}
+
+	if rc.PostBuildCooldown != nil {
+		c.PostBuildCooldown = rc.PostBuildCooldown.Duration
+	}
+	if c.PostBuildCooldown < 0 {
+		errs = append(errs, fmt.Errorf("invalid PostBuildCooldown %v: must not be negative", c.PostBuildCooldown))
+	}
+
+	if rc.MaxBuildsPerMinute != nil {
+		c.MaxBuildsPerMinute = *rc.MaxBuildsPerMinute
+	}
+	if c.MaxBuildsPerMinute < 0 {
+		errs = append(errs, fmt.Errorf("invalid MaxBuildsPerMinute %v: must not be negative", c.MaxBuildsPerMinute))
+	}
+	if rc.MinBuildInterval != nil {
+		c.MinBuildInterval = rc.MinBuildInterval.Duration
+	}
+	if c.MinBuildInterval < 0 {
+		errs = append(errs, fmt.Errorf("invalid MinBuildInterval %v: must not be negative", c.MinBuildInterval))
+	}
+
+	c.KillSignal = DEFAULT_KILL_SIGNAL
+	if rc.KillSignal != nil {
+		c.KillSignal, err = parseSignal(rc.KillSignal)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid KillSignal: %v", err))
+		}
+	}
+
+	c.KillGracePeriod = DEFAULT_KILL_GRACE_PERIOD
+	if rc.KillGracePeriod != nil {
+		c.KillGracePeriod = rc.KillGracePeriod.Duration
+	}
+	if c.KillGracePeriod < 0 {
+		errs = append(errs, fmt.Errorf("invalid KillGracePeriod %v: must not be negative", c.KillGracePeriod))
+	}
+
+	if rc.FastRestart != nil {
+		c.FastRestart = *rc.FastRestart
+	}
+	if rc.FinishThenRebuild != nil {
+		c.FinishThenRebuild = *rc.FinishThenRebuild
+	}
+	if c.FastRestart && c.FinishThenRebuild {
+		errs = append(errs, fmt.Errorf("use one of FastRestart and FinishThenRebuild, not both"))
+	}
+
+	c.WatchExcludes = watchExcludes(c)
+	c.Warnings = warns
+
+	if len(errs) > 0 {
+		return c, errs
+	}
+	return c, nil
+}
+
+func readDockerConfig(rd rawDockerConfig, confdir string) (*DockerConfig, error) {
+	d := DockerConfig{Workdir: DEFAULT_DOCKER_WORKDIR}
+	if rd.Image == nil || *rd.Image == "" {
+		return nil, fmt.Errorf("missing required config value: Docker.Image")
+	}
+	d.Image = *rd.Image
+	if rd.Workdir != nil {
+		d.Workdir = *rd.Workdir
+	}
+	if !path.IsAbs(d.Workdir) {
+		return nil, fmt.Errorf("invalid Docker.Workdir %q: must be absolute", d.Workdir)
+	}
+	for _, v := range rd.Volumes {
+		parts := strings.SplitN(v, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid Docker.Volumes entry %q: must be like host:container", v)
+		}
+		host, err := RerootPath(parts[0], confdir)
+		if err != nil {
+			return nil, err
+		}
+		d.Volumes = append(d.Volumes, host+":"+parts[1])
+	}
+	return &d, nil
+}
+
+func readRemoteConfig(rr rawRemoteConfig) (*RemoteConfig, error) {
+	var r RemoteConfig
+	if rr.Host == nil || *rr.Host == "" {
+		return nil, fmt.Errorf("missing required config value: Remote.Host")
+	}
+	r.Host = *rr.Host
+	if rr.User != nil {
+		r.User = *rr.User
+	}
+	if rr.RemoteDir == nil || *rr.RemoteDir == "" {
+		return nil, fmt.Errorf("missing required config value: Remote.RemoteDir")
+	}
+	r.RemoteDir = *rr.RemoteDir
+	return &r, nil
+}
+
+// Read the global config at gpath with its paths made absolute.
+// Returns nil if there is no global config.
+func readGlobalConfig(gpath string) (*rawConfig, error) {
+	var rc rawConfig
+	_, err := toml.DecodeFile(gpath, &rc)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	rc.expandEnv()
+	err = rc.reroot(path.Dir(gpath))
+	if err != nil {
+		return nil, err
+	}
+	return &rc, nil
+}
+
+// CheckConfig checks that the programs and files a config needs exist on this machine,
+// beyond the directories that ReadConfig already checks.
+// Returns every problem found, or nil.
+func CheckConfig(c Config) ConfigErrors {
+	var errs ConfigErrors
+	check := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.UseJustASec && len(c.BuildFiles) > 0 {
+		for _, p := range c.BuildFiles {
+			check(checkDir("BuildFile directory", path.Dir(p)))
+		}
+		check(checkExecutable("justasec", "needed to replace BuildFile"))
+	}
+	switch {
+	case c.Docker != nil:
+		check(checkExecutable("docker", "needed to build in Docker.Image"))
+	case c.Remote != nil:
+		check(checkExecutable("rsync", "needed to copy WatchDir to Remote.Host"))
+		check(checkExecutable("ssh", "needed to build on Remote.Host"))
+	case len(c.BuildArgv) > 0:
+		if !strings.Contains(c.BuildArgv[0], "{{") {
+			check(checkExecutable(c.BuildArgv[0], "needed to run BuildArgv"))
+		}
+	default:
+		check(checkExecutable("bash", "needed to run BuildCmd"))
+	}
+	if c.WatchBackend == WATCH_BACKEND_FSWATCH {
+		check(checkExecutable("fswatch", "needed to watch for changes, "+FSWATCH_INSTALL_HINT))
+	}
+	return errs
+}
+
+// DirError is a config directory that is missing or not a directory.
+type DirError struct {
+	Name   string
+	Path   string
+	NotDir bool
+	Err    error
+}
+
+func (e DirError) Error() string {
+	switch {
+	case e.NotDir:
+		return fmt.Sprintf("%v %v exists but is not a directory", e.Name, e.Path)
+	case e.Err != nil:
+		return fmt.Sprintf("%v %v: %v", e.Name, e.Path, e.Err)
+	default:
+		return fmt.Sprintf("%v %v does not exist", e.Name, e.Path)
+	}
+}
+
+// Check that a directory exists. Empty paths are skipped.
+// Returns a DirError if not.
+func checkDir(name string, p string) error {
+	if len(p) == 0 {
+		return nil
+	}
+	info, err := os.Stat(p)
+	switch {
+	case os.IsNotExist(err):
+		return DirError{Name: name, Path: p}
+	case err != nil:
+		return DirError{Name: name, Path: p, Err: err}
+	case !info.IsDir():
+		return DirError{Name: name, Path: p, NotDir: true}
+	}
+	return nil
+}
+
+// Check that StatusFile's directory exists, so a bad path is reported once
+// instead of failing every write. Creates it first if create is set.
+func checkStatusDir(dir string, create bool) error {
+	if create {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return DirError{Name: "StatusFile directory", Path: dir, Err: err}
+		}
+	}
+	return checkDir("StatusFile directory", dir)
+}
+
+// Check that a program is on PATH.
+func checkExecutable(name string, why string) error {
+	p, err := which(name)
+	if err != nil {
+		return fmt.Errorf("could not look for %v: %v", name, err)
+	}
+	if p == nil {
+		return fmt.Errorf("%v not found in PATH, %v", name, why)
+	}
+	return nil
+}
+
+// PrintConfig logs the config in a human readable form.
+func PrintConfig(c Config) {
+	pf := func(a string, b string) {
+		logInfo("%s:\n  %s\n", a, b)
+	}
+	pfo := func(a string, b *string) {
+		if b == nil {
+			logInfo("%s: None\n", a)
+		} else {
+			pf(a, *b)
+		}
+	}
+	if c.Task != "" {
+		pf("Task", c.Task)
+	}
+	pf("WatchDir", c.WatchDir)
+	if len(c.ExtraWatchPaths) > 0 {
+		pf("ExtraWatchPaths", strings.Join(c.ExtraWatchPaths, "\n  "))
+	}
+	if len(c.WatchExcludes) > 0 {
+		pf("WatchExcludes (written by builderator)", strings.Join(c.WatchExcludes, "\n  "))
+	}
+	if c.Ignore != nil {
+		pf("Ignore", fmt.Sprintf("%v patterns from %v", len(c.Ignore.Patterns), c.Ignore.Path))
+	}
+	if len(c.BuildArgv) > 0 {
+		pf("BuildArgv", fmt.Sprintf("%q", c.BuildArgv))
+	} else if c.BuildCmdOS != "" {
+		pf("BuildCmd", fmt.Sprintf("%v (from BuildCmdByOS.%v)", c.BuildCmd, c.BuildCmdOS))
+	} else {
+		pf("BuildCmd", c.BuildCmd)
+	}
+	for _, rule := range c.Rules {
+		pf("Rule", fmt.Sprintf("%v: %v", rule.Match, rule.Cmd))
+	}
+	pf("BuildCmdDir", c.BuildCmdDir)
+	if c.Docker != nil {
+		pf("Docker", fmt.Sprintf("%v with WatchDir at %v", c.Docker.Image, c.Docker.Workdir))
+	}
+	if c.Remote != nil {
+		pf("Remote", c.Remote.target()+":"+c.Remote.RemoteDir)
+	}
+	pfo("StatusFile", c.StatusFile)
+	if c.AggregateStatusFile != nil {
+		pf("AggregateStatusFile", *c.AggregateStatusFile)
+	}
+	if c.HeartbeatFile != nil {
+		pf("Heartbeat", fmt.Sprintf("%v every %v", *c.HeartbeatFile, c.HeartbeatInterval))
+	}
+	pf("StatusFormat", c.StatusFormat)
+	pf("StatusFileMode", fmt.Sprintf("%04o", uint32(c.StatusFileMode)))
+	if c.StatusWriteInterval > 0 {
+		pf("StatusWriteInterval", c.StatusWriteInterval.String())
+	}
+	pf("StripANSI", fmt.Sprint(c.StripANSI))
+	if c.StatusOnlyOutputOnFailure {
+		pf("StatusOnlyOutputOnFailure", "true")
+	}
+	if c.TooltipLines > 0 {
+		if c.StatusFormat == STATUS_FORMAT_JSON {
+			pf("TooltipLines", fmt.Sprintf("%v in the tail field", c.TooltipLines))
+		} else {
+			pf("TooltipLines", fmt.Sprintf("%v in %v", c.TooltipLines, TooltipFile(*c.StatusFile)))
+		}
+	}
+	if c.CreateStatusDir {
+		pf("CreateStatusDir", "true")
+	}
+	if len(c.BuildFiles) == 0 {
+		logInfo("BuildFile: None\n")
+	} else {
+		pf("BuildFile", strings.Join(c.BuildFiles, "\n  "))
+		pf("UseJustASec", fmt.Sprint(c.UseJustASec))
+	}
+	if c.BuildEnvFile != nil {
+		pf("BuildEnvFile", *c.BuildEnvFile)
+	}
+	if c.PreBuildCmd != "" {
+		pf("PreBuildCmd", c.PreBuildCmd)
+	}
+	pf("PassChangedFiles", fmt.Sprint(c.PassChangedFiles))
+	if c.GoPackageScoped {
+		pf("GoPackageCmd", c.GoPackageCmd)
+	}
+	pf("StreamOutput", fmt.Sprint(c.StreamOutput))
+	if c.StatusFlushInterval > 0 {
+		pf("StatusFlushInterval", c.StatusFlushInterval.String())
+	}
+	if c.ClearScreen {
+		pf("ClearScreen", "true")
+	}
+	if c.UsePTY {
+		pf("UsePTY", "true")
+	}
+	pf("AlwaysReport", fmt.Sprint(c.AlwaysReport))
+	if c.OnFailureSound != "" {
+		pf("OnFailureSound", c.OnFailureSound)
+		pf("SuppressFirstNotification", fmt.Sprint(c.SuppressFirstNotification))
+	}
+	if c.EscalateAfterFailures > 0 {
+		pf("EscalateAfterFailures", fmt.Sprint(c.EscalateAfterFailures))
+	}
+	if c.NotifyAfter > 0 {
+		pf("NotifyAfter", c.NotifyAfter.String())
+	}
+	if c.OnStatusChange != "" {
+		pf("OnStatusChange", c.OnStatusChange)
+	}
+	if c.OnSuccessCmd != "" {
+		pf("OnSuccessCmd", c.OnSuccessCmd)
+	}
+	if c.StatusBarPort > 0 {
+		pf("StatusBar", fmt.Sprintf("%v port %v, titles %v, starting %v, keepalive %v, timeout %v", c.StatusBarProto, c.StatusBarPort, c.StatusBarTitles, c.InitBuildColor, c.StatusBarKeepAlive, c.StatusBarTimeout))
+		var colors []string
+		for _, state := range statusStates {
+			colors = append(colors, state+"="+c.StatusBarColors[state])
+		}
+		pf("StatusBarColors", strings.Join(colors, ", "))
+	}
+	pf("BuildOnStart", fmt.Sprint(c.BuildOnStart))
+	if c.BuildRetries > 0 {
+		pf("BuildRetries", fmt.Sprintf("%v, %v apart", c.BuildRetries, c.BuildRetryDelay))
+	}
+	pf("MaxOutputBytes", fmt.Sprint(c.MaxOutputBytes))
+	if c.ControlPort > 0 {
+		pf("Control", net.JoinHostPort(c.ControlHost, fmt.Sprint(c.ControlPort)))
+		pf("HistorySize", fmt.Sprint(c.HistorySize))
+	}
+	if c.MetricsPort > 0 {
+		pf("Metrics", net.JoinHostPort(c.ControlHost, fmt.Sprint(c.MetricsPort)))
+	}
+	if c.WatchBackendAuto {
+		pf("WatchBackend", c.WatchBackend+" (auto)")
+	} else {
+		pf("WatchBackend", c.WatchBackend)
+	}
+	if c.WatchBackend == WATCH_BACKEND_POLL {
+		pf("PollInterval", c.PollInterval.String())
+	}
+	if c.DebounceWindow > 0 {
+		pf("Debounce", fmt.Sprintf("%v %v", c.DebounceMode, c.DebounceWindow))
+	}
+	if c.PostBuildCooldown > 0 {
+		pf("PostBuildCooldown", c.PostBuildCooldown.String())
+	}
+	if c.MaxBuildsPerMinute > 0 {
+		pf("MaxBuildsPerMinute", fmt.Sprint(c.MaxBuildsPerMinute))
+	}
+	if c.MinBuildInterval > 0 {
+		pf("MinBuildInterval", c.MinBuildInterval.String())
+	}
+	pf("KillSignal", fmt.Sprintf("%v (%d)", c.KillSignal, int(c.KillSignal)))
+	pf("KillGracePeriod", c.KillGracePeriod.String())
+	if c.FastRestart {
+		pf("FastRestart", "true")
+	}
+	if c.FinishThenRebuild {
+		pf("FinishThenRebuild", "true")
+	}
+}
+
+var signalNames = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"KILL": syscall.SIGKILL,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+	"TERM": syscall.SIGTERM,
+}
+
+// Parse a signal from a name like "TERM" or "SIGTERM" or a number.
+func parseSignal(v interface{}) (syscall.Signal, error) {
+	switch v := v.(type) {
+	case int64:
+		if v <= 0 || v > 64 {
+			return 0, fmt.Errorf("signal number %v out of range", v)
+		}
+		return syscall.Signal(v), nil
+	case string:
+		if n, err := strconv.Atoi(v); err == nil {
+			return parseSignal(int64(n))
+		}
+		sig, ok := signalNames[strings.TrimPrefix(strings.ToUpper(v), "SIG")]
+		if !ok {
+			return 0, fmt.Errorf("unknown signal %q", v)
+		}
+		return sig, nil
+	default:
+		return 0, fmt.Errorf("must be a signal name or number, got %v", v)
+	}
+}
+
+// Read BuildFile, which is a path or a list of paths. Returns nil if it's unset.
+func parseBuildFiles(v interface{}) ([]string, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{v}, nil
+	case []string:
+		return v, nil
+	case []interface{}:
+		files := []string{}
+		for _, f := range v {
+			s, ok := f.(string)
+			if !ok {
+				return nil, fmt.Errorf("must be a path or a list of paths, got %v", f)
+			}
+			files = append(files, s)
+		}
+		return files, nil
+	default:
+		return nil, fmt.Errorf("must be a path or a list of paths, got %v", v)
+	}
+}
+
+func isStatusBarColor(style string) bool {
+	for _, color := range StatusBarColors {
+		if style == color {
+			return true
+		}
+	}
+	return false
+}
+
+// Check that a port config value is 0 (disabled) or a valid port number.
+func checkPort(name string, port int) error {
+	if port < 0 || port > 65535 {
+		return fmt.Errorf("invalid %v %v: must be 0 (disabled) or between 1 and 65535", name, port)
+	}
+	return nil
+}
+
+// ParseFileMode parses octal permissions like "0600".
+func ParseFileMode(s string) (os.FileMode, error) {
+	n, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not an octal file mode", s)
+	}
+	if n&^uint64(os.ModePerm) != 0 {
+		return 0, fmt.Errorf("%q has bits outside of 0777", s)
+	}
+	return os.FileMode(n), nil
+}
+
+// RerootPath takes a path and makes sure it's absolute.
+// If it was relative, it is treated as relative to relto.
+// Environment variables are not expanded here, ReadConfig already expanded them in config values.
+func RerootPath(p string, relto string) (string, error) {
+	var err error
+	p, err = Homeopathy(p)
+	if err != nil {
+		return "", err
+	}
+	if !path.IsAbs(p) {
+		p = path.Join(relto, p)
+	}
+	p = path.Clean(p)
+	return p, nil
+}
+
+// BUILD_CMD_DIR_WATCHDIR at the start of BuildCmdDir stands for WatchDir.
+const BUILD_CMD_DIR_WATCHDIR = "$WATCHDIR"
+
+// Replace a leading BUILD_CMD_DIR_WATCHDIR in p with watchDir.
+// Returns whether p had one.
+func expandWatchDir(p string, watchDir string) (string, bool) {
+	for _, prefix := range []string{BUILD_CMD_DIR_WATCHDIR, "${WATCHDIR}"} {
+		if p == prefix || strings.HasPrefix(p, prefix+"/") {
+			return watchDir + strings.TrimPrefix(p, prefix), true
+		}
+	}
+	return p, false
+}
+
+// Homeopathy takes a path and expands the ~ or ~user part of it if there is one.
+// It is not always possible to do this, or so they say.
+func Homeopathy(p string) (string, error) {
+	if !strings.HasPrefix(p, "~") {
+		return p, nil
+	}
+	name, rest := p[1:], ""
+	if i := strings.Index(name, "/"); i >= 0 {
+		name, rest = name[:i], name[i+1:]
+	}
+
+	var usr *user.User
+	var err error
+	if len(name) == 0 {
+		usr, err = user.Current()
+	} else {
+		usr, err = user.Lookup(name)
+		if _, ok := err.(user.UnknownUserError); ok {
+			return "", fmt.Errorf("could not expand %v: no such user %q", p, name)
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	dir := usr.HomeDir
+	if len(dir) == 0 {
+		return "", errors.New("no user homedir set")
+	}
+	return path.Join(dir, rest), nil
+}
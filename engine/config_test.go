@@ -0,0 +1,416 @@
+package engine
+
+import (
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestHomeopathy(t *testing.T) {
+	me, err := user.Current()
+	if err != nil {
+		t.Skipf("no current user: %v", err)
+	}
+	cases := []struct {
+		in       string
+		expected string
+		fails    bool
+	}{
+		{in: "x/y", expected: "x/y"},
+		{in: "/x/~", expected: "/x/~"},
+		{in: "~", expected: me.HomeDir},
+		{in: "~/x", expected: path.Join(me.HomeDir, "x")},
+		{in: "~" + me.Username, expected: me.HomeDir},
+		{in: "~" + me.Username + "/x/y", expected: path.Join(me.HomeDir, "x/y")},
+		{in: "~no-such-builderator-user/x", fails: true},
+	}
+	for _, tc := range cases {
+		out, err := Homeopathy(tc.in)
+		switch {
+		case tc.fails && err == nil:
+			t.Errorf("%v => %v, expected an error", tc.in, out)
+		case !tc.fails && err != nil:
+			t.Errorf("%v => error %v", tc.in, err)
+		case !tc.fails && out != path.Clean(tc.expected):
+			t.Errorf("%v => %v, expected %v", tc.in, out, tc.expected)
+		}
+	}
+}
+
+func TestReadTasks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "builderator-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, d := range []string{"web", "api"} {
+		err := os.Mkdir(filepath.Join(dir, d), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	cpath := filepath.Join(dir, "builderator.toml")
+	err = ioutil.WriteFile(cpath, []byte(`BuildCmd = "make"
+StreamOutput = true
+
+[[Task]]
+Name = "web"
+WatchDir = "web"
+BuildCmd = "npm run build"
+
+[[Task]]
+Name = "api"
+WatchDir = "api"
+StreamOutput = false
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cs, err := ReadTasks(cpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cs) != 2 {
+		t.Fatalf("read %v tasks, expected 2", len(cs))
+	}
+	web, api := cs[0], cs[1]
+	if web.Task != "web" || web.WatchDir != filepath.Join(dir, "web") || web.BuildCmd != "npm run build" || !web.StreamOutput {
+		t.Errorf("web read as %+v", web)
+	}
+	if api.Task != "api" || api.WatchDir != filepath.Join(dir, "api") || api.BuildCmd != "make" || api.StreamOutput {
+		t.Errorf("api read as %+v", api)
+	}
+
+	c, err := ReadTask(cpath, "api")
+	if err != nil || c.WatchDir != api.WatchDir {
+		t.Errorf("ReadTask api => %+v %v", c, err)
+	}
+	if _, err := ReadConfig(cpath); err == nil {
+		t.Error("ReadConfig should refuse a config with tasks")
+	}
+}
+
+func TestBuildArgv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "builderator-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cpath := filepath.Join(dir, "builderator.toml")
+	read := func(contents string) (Config, error) {
+		err := ioutil.WriteFile(cpath, []byte(contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ReadConfig(cpath)
+	}
+
+	c, err := read(`WatchDir = "."
+BuildArgv = ["go", "build", "./..."]
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.BuildCmdString() != `'go' 'build' './...'` {
+		t.Errorf("BuildArgv read as %q", c.BuildCmdString())
+	}
+
+	_, err = read(`WatchDir = "."
+BuildCmd = "make"
+BuildArgv = ["make"]
+`)
+	if err == nil {
+		t.Error("expected an error with both BuildCmd and BuildArgv")
+	}
+
+	// A task's BuildArgv replaces the top level BuildCmd.
+	err = ioutil.WriteFile(cpath, []byte(`WatchDir = "."
+BuildCmd = "make"
+[[Task]]
+Name = "argv"
+BuildArgv = ["make", "argv"]
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs, err := ReadTasks(cpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cs[0].BuildCmd != "" || len(cs[0].BuildArgv) != 2 {
+		t.Errorf("task read as %+v", cs[0])
+	}
+}
+
+func TestStatusDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "builderator-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cpath := filepath.Join(dir, "builderator.toml")
+	read := func(contents string) (Config, error) {
+		err := ioutil.WriteFile(cpath, []byte(contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ReadConfig(cpath)
+	}
+
+	_, err = read(`WatchDir = "."
+BuildCmd = "make"
+StatusFile = "missing/status"
+`)
+	errs, ok := err.(ConfigErrors)
+	if !ok || len(errs) != 1 {
+		t.Fatalf("expected one error for the missing StatusFile directory, got %v", err)
+	}
+	if _, ok := errs[0].(DirError); !ok {
+		t.Errorf("expected a DirError, got %v", errs[0])
+	}
+
+	_, err = read(`WatchDir = "."
+BuildCmd = "make"
+StatusFile = "missing/status"
+CreateStatusDir = true
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "missing")); err != nil || !info.IsDir() {
+		t.Errorf("expected StatusFile directory to be created: %v", err)
+	}
+}
+
+func TestBuildCmdDirWatchDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "builderator-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(filepath.Join(dir, "src", "cmd"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	cpath := filepath.Join(dir, "builderator.toml")
+
+	for _, tc := range []struct {
+		buildCmdDir string
+		expected    string
+	}{
+		{"$WATCHDIR", filepath.Join(dir, "src")},
+		{"${WATCHDIR}/cmd", filepath.Join(dir, "src", "cmd")},
+		{".", dir},
+	} {
+		err := ioutil.WriteFile(cpath, []byte(`WatchDir = "src"
+BuildCmd = "make"
+BuildCmdDir = "`+tc.buildCmdDir+`"
+`), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := ReadConfig(cpath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.BuildCmdDir != tc.expected {
+			t.Errorf("BuildCmdDir %q resolved to %v, expected %v", tc.buildCmdDir, c.BuildCmdDir, tc.expected)
+		}
+	}
+}
+
+func TestReadRulesFromConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "builderator-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cpath := filepath.Join(dir, "builderator.toml")
+	err = ioutil.WriteFile(cpath, []byte(`WatchDir = "."
+BuildCmd = "make"
+[[Rule]]
+Match = '\.proto$'
+Cmd = "make proto"
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := ReadConfig(cpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Rules) != 1 || c.Rules[0].Match.String() != `\.proto$` || c.Rules[0].Cmd != "make proto" {
+		t.Errorf("rules read as %+v", c.Rules)
+	}
+}
+
+func TestBuildCmdByOS(t *testing.T) {
+	cmd := "make"
+	argv := []string{"make", "argv"}
+	rc := rawConfig{
+		BuildCmd:     &cmd,
+		BuildCmdByOS: map[string]string{"darwin": "make CC=clang", "windows": "nmake"},
+	}
+
+	goos, err := resolveBuildCmdByOS(&rc, "linux")
+	if err != nil || goos != "" || *rc.BuildCmd != "make" {
+		t.Errorf("linux resolved to %q from %q, %v", *rc.BuildCmd, goos, err)
+	}
+	rc.BuildArgv = argv
+	goos, err = resolveBuildCmdByOS(&rc, "darwin")
+	if err != nil || goos != "darwin" || *rc.BuildCmd != "make CC=clang" || rc.BuildArgv != nil {
+		t.Errorf("darwin resolved to %q %q from %q, %v", *rc.BuildCmd, rc.BuildArgv, goos, err)
+	}
+
+	rc.BuildCmdByOS = map[string]string{"macos": "make"}
+	if _, err := resolveBuildCmdByOS(&rc, "darwin"); err == nil {
+		t.Error("expected an error for an unknown GOOS")
+	}
+
+	// A project's BuildCmd replaces the global config's BuildCmdByOS.
+	global := rawConfig{BuildCmdByOS: map[string]string{"linux": "global"}}
+	merged := mergeRawConfig(global, rawConfig{BuildCmd: &cmd})
+	if merged.BuildCmdByOS != nil {
+		t.Errorf("merged BuildCmdByOS %v", merged.BuildCmdByOS)
+	}
+}
+
+func TestStatusBarColors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "builderator-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cpath := filepath.Join(dir, "builderator.toml")
+	read := func(contents string) (Config, error) {
+		err := ioutil.WriteFile(cpath, []byte(`WatchDir = "."
+BuildCmd = "make"
+`+contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ReadConfig(cpath)
+	}
+
+	c, err := read(`[StatusBarColors]
+building = "spinner"
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.StatusBarColors[STATE_BUILDING] != "spinner" || c.StatusBarColors[STATE_FAILED] != StatusBarRed {
+		t.Errorf("StatusBarColors read as %v", c.StatusBarColors)
+	}
+	// BuildOnStart defaults to true, so it starts out building.
+	if c.InitBuildColor != "spinner" {
+		t.Errorf("InitBuildColor defaulted to %v", c.InitBuildColor)
+	}
+	if len(c.Warnings) != 1 {
+		t.Errorf("expected a warning for the custom image, got %v", c.Warnings)
+	}
+
+	_, err = read(`StatusBarStrictColors = true
+[StatusBarColors]
+building = "spinner"
+`)
+	if err == nil {
+		t.Error("expected an error for a custom image with StatusBarStrictColors")
+	}
+
+	_, err = read(`[StatusBarColors]
+compiling = "blue"
+`)
+	if err == nil {
+		t.Error("expected an error for an unknown state")
+	}
+}
+
+func TestBuildFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "builderator-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cpath := filepath.Join(dir, "builderator.toml")
+	read := func(buildFile string) (Config, error) {
+		err := ioutil.WriteFile(cpath, []byte(`WatchDir = "."
+BuildCmd = "make"
+BuildFile = `+buildFile+`
+`), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ReadConfig(cpath)
+	}
+
+	c, err := read(`"bin/server"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c.BuildFiles, []string{filepath.Join(dir, "bin/server")}) || !c.UseJustASec {
+		t.Errorf("BuildFile read as %q, UseJustASec %v", c.BuildFiles, c.UseJustASec)
+	}
+
+	c, err = read(`["bin/server", "/usr/local/bin/client"]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c.BuildFiles, []string{filepath.Join(dir, "bin/server"), "/usr/local/bin/client"}) {
+		t.Errorf("BuildFile list read as %q", c.BuildFiles)
+	}
+
+	_, err = read(`["bin/server", 3]`)
+	if err == nil {
+		t.Error("expected an error for a BuildFile that isn't a path")
+	}
+}
+
+func TestResolveWatchBackend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "builderator-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	oldPath := os.Getenv("PATH")
+	defer os.Setenv("PATH", oldPath)
+	os.Setenv("PATH", dir)
+
+	str := func(s string) *string { return &s }
+	cases := []struct {
+		backend  *string
+		mode     *string
+		expected string
+		auto     bool
+	}{
+		{nil, nil, WATCH_BACKEND_POLL, true},
+		{str("auto"), nil, WATCH_BACKEND_POLL, true},
+		{str("fswatch"), nil, WATCH_BACKEND_FSWATCH, false},
+		{str("poll"), nil, WATCH_BACKEND_POLL, false},
+		{nil, str("event"), WATCH_BACKEND_FSWATCH, false},
+		{nil, str("poll"), WATCH_BACKEND_POLL, false},
+	}
+	for i, tc := range cases {
+		b, auto, err := resolveWatchBackend(tc.backend, tc.mode)
+		if err != nil || b != tc.expected || auto != tc.auto {
+			t.Errorf("case %v resolved to %q auto %v, %v", i, b, auto, err)
+		}
+	}
+
+	// With fswatch installed auto picks it.
+	if err := ioutil.WriteFile(filepath.Join(dir, "fswatch"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if b, auto, err := resolveWatchBackend(nil, nil); err != nil || b != WATCH_BACKEND_FSWATCH || !auto {
+		t.Errorf("auto with fswatch resolved to %q auto %v, %v", b, auto, err)
+	}
+
+	for i, bad := range [][2]*string{{str("inotify"), nil}, {nil, str("fswatch")}, {str("poll"), str("poll")}} {
+		if _, _, err := resolveWatchBackend(bad[0], bad[1]); err == nil {
+			t.Errorf("expected an error for bad case %v", i)
+		}
+	}
+}
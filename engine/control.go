@@ -0,0 +1,71 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// How long to wait for in-flight requests when Run stops.
+const SERVER_SHUTDOWN_TIMEOUT = time.Second
+
+// Start the HTTP control server on ControlHost:ControlPort.
+// POST /build triggers a rebuild by sending into `watchCh`,
+// or answers 503 if Run is stopping or the request goes away first.
+// GET /status returns the current state and last build result as StatusJSON.
+// GET /history returns the recent builds as a list of HistoryEntry.
+// Returns quick.
+func (r *Runner) serveControl(ctx context.Context, c Config, watchCh chan<- []string) (*http.Server, error) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/build", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		logInfo("rebuild requested over http")
+		select {
+		case watchCh <- nil:
+			w.WriteHeader(http.StatusAccepted)
+		case <-req.Context().Done():
+			http.Error(w, "request canceled", http.StatusServiceUnavailable)
+		case <-ctx.Done():
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		}
+	})
+	mux.HandleFunc("/status", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			http.Error(w, "use GET", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(r.currentStatus())
+	})
+	mux.HandleFunc("/history", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			http.Error(w, "use GET", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(r.History())
+	})
+
+	ln, err := net.Listen("tcp", net.JoinHostPort(c.ControlHost, strconv.Itoa(c.ControlPort)))
+	if err != nil {
+		return nil, err
+	}
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(ln)
+	return srv, nil
+}
+
+// Stop an HTTP server, waiting at most SERVER_SHUTDOWN_TIMEOUT for open requests.
+func shutdownServer(srv *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), SERVER_SHUTDOWN_TIMEOUT)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		srv.Close()
+	}
+}
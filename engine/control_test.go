@@ -0,0 +1,36 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestControlBuildWhileStopping(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := Config{ControlHost: "127.0.0.1", ControlPort: ln.Addr().(*net.TCPAddr).Port}
+	ln.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	// Nothing reads the channel, like a Run loop that has returned.
+	watchCh := make(chan []string)
+	srv, err := NewRunner(c).serveControl(ctx, c, watchCh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdownServer(srv)
+	cancel()
+
+	resp, err := http.Post(fmt.Sprintf("http://127.0.0.1:%v/build", c.ControlPort), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("got status %v, want %v", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+}
@@ -0,0 +1,115 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// Build on the first change, then ignore changes until DebounceWindow passes.
+	DEBOUNCE_LEADING = "leading"
+	// Build once changes have stopped for DebounceWindow.
+	DEBOUNCE_TRAILING = "trailing"
+)
+
+// debouncer decides when batches of changes from the watcher start a build.
+// It is driven with explicit times so tests can fake the clock.
+type debouncer struct {
+	mu     sync.Mutex
+	mode   string
+	window time.Duration
+	// Set before run to explain held changes.
+	explain explainer
+
+	// Trailing: changes waiting for the quiet period to end.
+	pending     []string
+	havePending bool
+	// Trailing: when to pass on pending. Leading: when the quiet period ends.
+	deadline time.Time
+}
+
+func newDebouncer(mode string, window time.Duration) *debouncer {
+	return &debouncer{mode: mode, window: window}
+}
+
+// Change the settings, as on config reload.
+func (d *debouncer) configure(mode string, window time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.mode = mode
+	d.window = window
+}
+
+// Record a batch of changes arriving at `now`.
+// Returns the changes to build now, if it's time.
+func (d *debouncer) add(files []string, now time.Time) ([]string, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch {
+	case d.window <= 0:
+		return files, true
+	case d.mode == DEBOUNCE_LEADING:
+		if now.Before(d.deadline) {
+			d.explain.explain(files, fmt.Sprintf("dropped, within DebounceWindow (%v) of the last change that built (leading)", d.window))
+			return nil, false
+		}
+		d.deadline = now.Add(d.window)
+		return files, true
+	default:
+		d.pending = mergeChanged(d.pending, files)
+		d.havePending = true
+		d.deadline = now.Add(d.window)
+		d.explain.explain(files, fmt.Sprintf("waiting for DebounceWindow (%v) without changes (trailing)", d.window))
+		return nil, false
+	}
+}
+
+// When tick should next be called, if ever.
+func (d *debouncer) next() (time.Time, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.deadline, d.havePending
+}
+
+// Returns the pending changes once their quiet period has passed at `now`.
+func (d *debouncer) tick(now time.Time) ([]string, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.havePending || now.Before(d.deadline) {
+		return nil, false
+	}
+	files := d.pending
+	d.pending = nil
+	d.havePending = false
+	return files, true
+}
+
+// Pass batches of changes from `in` to `out` as the debouncer allows until ctx is done.
+func (d *debouncer) run(ctx context.Context, in <-chan []string, out chan<- []string) {
+	send := func(files []string) {
+		select {
+		case out <- files:
+		case <-ctx.Done():
+		}
+	}
+	for {
+		var timer <-chan time.Time
+		if t, ok := d.next(); ok {
+			timer = time.After(time.Until(t))
+		}
+		select {
+		case files := <-in:
+			if files, ok := d.add(files, time.Now()); ok {
+				send(files)
+			}
+		case now := <-timer:
+			if files, ok := d.tick(now); ok {
+				send(files)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
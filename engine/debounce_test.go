@@ -0,0 +1,84 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// A fake clock for driving the debouncer.
+type fakeClock struct{ now time.Time }
+
+func (c *fakeClock) advance(d time.Duration) time.Time {
+	c.now = c.now.Add(d)
+	return c.now
+}
+
+func TestDebounceTrailingWaitsForQuiet(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	d := newDebouncer(DEBOUNCE_TRAILING, 100*time.Millisecond)
+
+	// A burst of changes 30ms apart.
+	for _, f := range []string{"a", "b", "a", "c"} {
+		if _, ok := d.add([]string{f}, clock.advance(30*time.Millisecond)); ok {
+			t.Fatalf("trailing built during the burst at %v", f)
+		}
+		if _, ok := d.tick(clock.now); ok {
+			t.Fatalf("trailing flushed during the burst at %v", f)
+		}
+	}
+
+	deadline, ok := d.next()
+	if !ok || !deadline.Equal(clock.now.Add(100*time.Millisecond)) {
+		t.Fatalf("next tick at %v %v, expected 100ms after the last change", deadline, ok)
+	}
+	if _, ok := d.tick(clock.advance(99 * time.Millisecond)); ok {
+		t.Fatal("flushed before the window passed")
+	}
+	files, ok := d.tick(clock.advance(time.Millisecond))
+	if !ok {
+		t.Fatal("did not flush once the window passed")
+	}
+	if !reflect.DeepEqual(files, []string{"a", "b", "c"}) {
+		t.Fatalf("flushed %v, expected the burst merged", files)
+	}
+	if _, ok := d.next(); ok {
+		t.Fatal("still pending after flushing")
+	}
+}
+
+func TestDebounceLeadingBuildsFirstChange(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	d := newDebouncer(DEBOUNCE_LEADING, 100*time.Millisecond)
+
+	files, ok := d.add([]string{"a"}, clock.now)
+	if !ok || !reflect.DeepEqual(files, []string{"a"}) {
+		t.Fatalf("leading did not build the first change right away: %v %v", files, ok)
+	}
+	// The flurry that follows is ignored.
+	for i := 0; i < 3; i++ {
+		if _, ok := d.add([]string{"b"}, clock.advance(30*time.Millisecond)); ok {
+			t.Fatal("leading built during the quiet period")
+		}
+	}
+	if _, ok := d.next(); ok {
+		t.Fatal("leading should never need a tick")
+	}
+	files, ok = d.add([]string{"c"}, clock.advance(10*time.Millisecond))
+	if !ok || !reflect.DeepEqual(files, []string{"c"}) {
+		t.Fatalf("leading did not build after the quiet period: %v %v", files, ok)
+	}
+}
+
+func TestDebounceZeroWindowPassesThrough(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	for _, mode := range []string{DEBOUNCE_LEADING, DEBOUNCE_TRAILING} {
+		d := newDebouncer(mode, 0)
+		for _, f := range []string{"a", "b"} {
+			files, ok := d.add([]string{f}, clock.now)
+			if !ok || !reflect.DeepEqual(files, []string{f}) {
+				t.Fatalf("%v with no window held back %v", mode, f)
+			}
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sync/atomic"
+	"syscall"
+)
+
+// DEFAULT_DOCKER_WORKDIR is where WatchDir is mounted in the container.
+const DEFAULT_DOCKER_WORKDIR = "/src"
+
+// DockerConfig runs builds in a container instead of locally.
+type DockerConfig struct {
+	Image string
+	// Extra mounts like "host:container". Relative host paths are relative to the config.
+	Volumes []string
+	// Where WatchDir is mounted and BuildCmd runs.
+	Workdir string
+}
+
+type rawDockerConfig struct {
+	Image   *string
+	Volumes []string
+	Workdir *string
+}
+
+var buildCount int64
+
+// A name for a build's container or remote process so that it can be killed when aborting.
+func nextBuildName() string {
+	n := atomic.AddInt64(&buildCount, 1)
+	return fmt.Sprintf("builderator-%v-%v", os.Getpid(), n)
+}
+
+// The command to run argv in a fresh container named `name`.
+// `envKeys` are variables to pass through from the command's environment.
+func dockerCommand(c Config, name string, argv []string, envKeys []string) *exec.Cmd {
+	d := c.Docker
+	args := []string{"run", "--rm", "--name", name,
+		"-v", c.WatchDir + ":" + d.Workdir,
+		"-w", d.Workdir}
+	for _, v := range d.Volumes {
+		args = append(args, "-v", v)
+	}
+	// Each takes its value from docker's own environment.
+	for _, k := range envKeys {
+		args = append(args, "-e", k)
+	}
+	if c.PassChangedFiles {
+		args = append(args, "-e", "BUILDERATOR_CHANGED_FILES")
+	}
+	args = append(args, d.Image)
+	args = append(args, argv...)
+	return exec.Command("docker", args...)
+}
+
+// Send a signal to a build's container.
+func dockerKill(name string, sig syscall.Signal) {
+	err := exec.Command("docker", "kill", fmt.Sprintf("--signal=%d", int(sig)), name).Run()
+	if err != nil {
+		logDebug("could not send %v to container %v: %v", sig, name, err)
+	}
+}
@@ -0,0 +1,70 @@
+package engine
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// WriteEffectiveCommands writes exactly what a build runs: each command's argv,
+// working directory and the environment it adds to builderator's own.
+// That is after BuildCmdByOS, templates, BuildEnvFile, PassChangedFiles, Docker and Remote.
+// It is the build with no changed files, like on start or a manual one, so Rules and
+// GoPackageScoped don't apply.
+func WriteEffectiveCommands(w io.Writer, c Config) error {
+	rel := relChanged(c, nil)
+	preCmd, buildCmd, buildArgv, err := renderCommands(c, rel)
+	if err != nil {
+		return err
+	}
+	var fileEnv []string
+	if c.BuildEnvFile != nil {
+		fileEnv, err = readEnvFile(*c.BuildEnvFile)
+		if err != nil {
+			return fmt.Errorf("Could not read BuildEnvFile: %v", err)
+		}
+	}
+
+	write := func(name string, buildCmd string, buildArgv []string) {
+		cmd, _ := buildCommand(c, buildCmd, buildArgv, rel, fileEnv)
+		fmt.Fprintf(w, "%v:\n", name)
+		fmt.Fprintf(w, "  dir: %v\n", cmd.Dir)
+		fmt.Fprintf(w, "  argv: %v\n", shellJoin(cmd.Args))
+		for _, kv := range envDiff(os.Environ(), cmd.Env) {
+			fmt.Fprintf(w, "  env: %v\n", kv)
+		}
+		if c.UsePTY {
+			fmt.Fprintf(w, "  on a pseudo-terminal (UsePTY)\n")
+		}
+	}
+	if preCmd != "" {
+		write("PreBuildCmd", preCmd, []string{"bash", "-c", preCmd})
+	}
+	write("BuildCmd", buildCmd, buildArgv)
+	return nil
+}
+
+// The entries of a command's environment `env` that aren't in `base`.
+// A nil env inherits base and adds nothing. Later entries win, as with exec.
+func envDiff(base []string, env []string) []string {
+	have := make(map[string]bool)
+	for _, kv := range base {
+		have[kv] = true
+	}
+	var diff []string
+	seen := make(map[string]int)
+	for _, kv := range env {
+		if have[kv] {
+			continue
+		}
+		key := strings.SplitN(kv, "=", 2)[0]
+		if i, ok := seen[key]; ok {
+			diff[i] = kv
+			continue
+		}
+		seen[key] = len(diff)
+		diff = append(diff, kv)
+	}
+	return diff
+}
@@ -0,0 +1,54 @@
+package engine
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEnvDiff(t *testing.T) {
+	base := []string{"HOME=/home/me", "FOO=old"}
+	cases := []struct {
+		env      []string
+		expected []string
+	}{
+		{nil, nil},
+		{base, nil},
+		{append(base, "FOO=new", "BAR=1"), []string{"FOO=new", "BAR=1"}},
+		{append(base, "BAR=1", "BAR=2"), []string{"BAR=2"}},
+	}
+	for _, tc := range cases {
+		if got := envDiff(base, tc.env); !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("envDiff(%v) = %v, expected %v", tc.env, got, tc.expected)
+		}
+	}
+}
+
+func TestWriteEffectiveCommands(t *testing.T) {
+	c := Config{
+		WatchDir:         "/src/project",
+		BuildCmd:         "make -C {{.WatchDir}}",
+		BuildCmdDir:      "/src/project/build",
+		PreBuildCmd:      "go mod download",
+		PassChangedFiles: true,
+	}
+	var b bytes.Buffer
+	if err := WriteEffectiveCommands(&b, c); err != nil {
+		t.Fatal(err)
+	}
+	expected := strings.Join([]string{
+		"PreBuildCmd:",
+		"  dir: /src/project/build",
+		"  argv: 'bash' '-c' 'go mod download'",
+		"  env: BUILDERATOR_CHANGED_FILES=",
+		"BuildCmd:",
+		"  dir: /src/project/build",
+		"  argv: 'bash' '-c' 'make -C /src/project'",
+		"  env: BUILDERATOR_CHANGED_FILES=",
+		"",
+	}, "\n")
+	if b.String() != expected {
+		t.Errorf("wrote\n%v\nexpected\n%v", b.String(), expected)
+	}
+}
@@ -0,0 +1,129 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Read a dotenv file into KEY=VALUE entries for a command's environment.
+func readEnvFile(p string) ([]string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	env, err := parseEnvFile(f)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", p, err)
+	}
+	return env, nil
+}
+
+// Parse dotenv lines like KEY=VALUE into KEY=VALUE entries.
+// Blank lines and lines starting with # are skipped, and a leading "export " is allowed.
+// Values may be 'single quoted' taken literally, "double quoted" with \n, \t, \" and \\ escapes,
+// or bare with a trailing " # comment" dropped.
+func parseEnvFile(r io.Reader) ([]string, error) {
+	var env []string
+	scanner := bufio.NewScanner(r)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		i := strings.Index(line, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("line %v: expected KEY=VALUE", lineno)
+		}
+		key := strings.TrimSpace(line[:i])
+		if !isEnvKey(key) {
+			return nil, fmt.Errorf("line %v: invalid variable name %q", lineno, key)
+		}
+		value, err := parseEnvValue(strings.TrimSpace(line[i+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %v", lineno, err)
+		}
+		env = append(env, key+"="+value)
+	}
+	return env, scanner.Err()
+}
+
+func parseEnvValue(s string) (string, error) {
+	switch {
+	case strings.HasPrefix(s, "'"):
+		end := strings.Index(s[1:], "'")
+		if end < 0 {
+			return "", fmt.Errorf("unterminated single quote")
+		}
+		return s[1 : end+1], checkEnvTrailer(s[end+2:])
+	case strings.HasPrefix(s, `"`):
+		var b bytes.Buffer
+		for i := 1; i < len(s); i++ {
+			switch s[i] {
+			case '"':
+				return b.String(), checkEnvTrailer(s[i+1:])
+			case '\\':
+				i++
+				if i == len(s) {
+					return "", fmt.Errorf("unterminated double quote")
+				}
+				switch s[i] {
+				case 'n':
+					b.WriteByte('\n')
+				case 't':
+					b.WriteByte('\t')
+				default:
+					b.WriteByte(s[i])
+				}
+			default:
+				b.WriteByte(s[i])
+			}
+		}
+		return "", fmt.Errorf("unterminated double quote")
+	default:
+		if i := strings.Index(s, " #"); i >= 0 {
+			s = s[:i]
+		}
+		return strings.TrimSpace(s), nil
+	}
+}
+
+// Only a comment may follow a quoted value.
+func checkEnvTrailer(s string) error {
+	s = strings.TrimSpace(s)
+	if s != "" && !strings.HasPrefix(s, "#") {
+		return fmt.Errorf("unexpected %q after quoted value", s)
+	}
+	return nil
+}
+
+func isEnvKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for i, r := range key {
+		switch {
+		case r == '_', r >= 'A' && r <= 'Z', r >= 'a' && r <= 'z':
+		case r >= '0' && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// The names in KEY=VALUE entries.
+func envKeys(env []string) []string {
+	var keys []string
+	for _, kv := range env {
+		keys = append(keys, strings.SplitN(kv, "=", 2)[0])
+	}
+	return keys
+}
@@ -0,0 +1,57 @@
+package engine
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseEnvFile(t *testing.T) {
+	in := `# a comment
+PLAIN=value
+export EXPORTED=1
+
+SPACED = spaced out  
+TRAILING=bare # comment
+HASH=a#b
+SINGLE='literal \n # not a comment' # comment
+DOUBLE="line\nnext \"quoted\" \\ # kept" # dropped
+EMPTY=
+EMPTY_QUOTES=""
+EQUALS=a=b
+`
+	env, err := parseEnvFile(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"PLAIN=value",
+		"EXPORTED=1",
+		"SPACED=spaced out",
+		"TRAILING=bare",
+		"HASH=a#b",
+		"SINGLE=literal \\n # not a comment",
+		"DOUBLE=line\nnext \"quoted\" \\ # kept",
+		"EMPTY=",
+		"EMPTY_QUOTES=",
+		"EQUALS=a=b",
+	}
+	if !reflect.DeepEqual(env, expected) {
+		t.Fatalf("parsed\n%q\nexpected\n%q", env, expected)
+	}
+}
+
+func TestParseEnvFileErrors(t *testing.T) {
+	for _, in := range []string{
+		"NOEQUALS",
+		"1BAD=x",
+		"BAD-NAME=x",
+		"OPEN='never closed",
+		`OPEN="never closed`,
+		`AFTER="quoted" extra`,
+	} {
+		if env, err := parseEnvFile(strings.NewReader(in)); err == nil {
+			t.Errorf("%q parsed as %q, expected an error", in, env)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package engine
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// ExpandConfigEnv expands $VAR and ${VAR} in a config value from the environment.
+// "$$" is a literal "$". Variables that aren't set are left as written, so bash still
+// expands its own like $f or $?, and so are $WATCHDIR and builderator's own BUILDERATOR_
+// variables, which mean something to the commands builderator runs.
+func ExpandConfigEnv(s string) string {
+	if !strings.Contains(s, "$") {
+		return s
+	}
+	var b bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		if s[i+1] == '$' {
+			b.WriteByte('$')
+			i++
+			continue
+		}
+		// The name and where the reference to it ends.
+		var name string
+		end := i + 1
+		if s[i+1] == '{' {
+			close := strings.IndexByte(s[i+2:], '}')
+			if close < 0 {
+				b.WriteByte(s[i])
+				continue
+			}
+			name = s[i+2 : i+2+close]
+			end = i + 2 + close + 1
+		} else {
+			for end < len(s) && isEnvNameByte(s[end], end == i+1) {
+				end++
+			}
+			name = s[i+1 : end]
+		}
+		value, ok := lookupConfigEnv(name)
+		if !ok {
+			b.WriteString(s[i:end])
+		} else {
+			b.WriteString(value)
+		}
+		i = end - 1
+	}
+	return b.String()
+}
+
+// The value of an environment variable for ExpandConfigEnv, if it should be expanded.
+func lookupConfigEnv(name string) (string, bool) {
+	if name == "" || name == "WATCHDIR" || strings.HasPrefix(name, "BUILDERATOR_") {
+		return "", false
+	}
+	for i := 0; i < len(name); i++ {
+		if !isEnvNameByte(name[i], i == 0) {
+			return "", false
+		}
+	}
+	return os.LookupEnv(name)
+}
+
+func isEnvNameByte(c byte, first bool) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || !first && '0' <= c && c <= '9'
+}
+
+// Expand environment variables in every string of a raw config with ExpandConfigEnv,
+// including lists, tables and [[Task]]s.
+func (rc *rawConfig) expandEnv() {
+	expandEnvValue(reflect.ValueOf(rc).Elem())
+}
+
+func expandEnvValue(v reflect.Value) {
+	if !v.CanSet() && v.Kind() != reflect.Ptr && v.Kind() != reflect.Struct {
+		return
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(ExpandConfigEnv(v.String()))
+	case reflect.Ptr:
+		if !v.IsNil() {
+			expandEnvValue(v.Elem())
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			expandEnvValue(v.Field(i))
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			expandEnvValue(v.Index(i))
+		}
+	case reflect.Map:
+		for _, k := range v.MapKeys() {
+			e := v.MapIndex(k)
+			if e.Kind() == reflect.String {
+				v.SetMapIndex(k, reflect.ValueOf(ExpandConfigEnv(e.String())))
+			}
+		}
+	case reflect.Interface:
+		// Values decoded into interface{} can't be set in place, so expand a copy.
+		if v.IsNil() {
+			return
+		}
+		switch e := v.Elem().Interface().(type) {
+		case string:
+			v.Set(reflect.ValueOf(ExpandConfigEnv(e)))
+		case []interface{}:
+			for i, x := range e {
+				if s, ok := x.(string); ok {
+					e[i] = ExpandConfigEnv(s)
+				}
+			}
+		}
+	}
+}
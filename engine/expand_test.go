@@ -0,0 +1,88 @@
+package engine
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestExpandConfigEnv(t *testing.T) {
+	os.Setenv("BUILDERATOR_TEST_VAR", "x")
+	defer os.Unsetenv("BUILDERATOR_TEST_VAR")
+	os.Setenv("TEST_EXPAND_HOME", "/home/me")
+	defer os.Unsetenv("TEST_EXPAND_HOME")
+	os.Unsetenv("TEST_EXPAND_UNSET")
+
+	cases := []struct {
+		in       string
+		expected string
+	}{
+		{"make", "make"},
+		{"$TEST_EXPAND_HOME/bin", "/home/me/bin"},
+		{"${TEST_EXPAND_HOME}bin", "/home/mebin"},
+		{"$$TEST_EXPAND_HOME", "$TEST_EXPAND_HOME"},
+		{"cost $$5", "cost $5"},
+		{"echo $$$$ $TEST_EXPAND_HOME", "echo $$ /home/me"},
+		{"$TEST_EXPAND_UNSET and ${TEST_EXPAND_UNSET}", "$TEST_EXPAND_UNSET and ${TEST_EXPAND_UNSET}"},
+		{"for f in *.go; do gofmt $f; done; exit $?", "for f in *.go; do gofmt $f; done; exit $?"},
+		{"cd $(dirname {{.ChangedFile}})", "cd $(dirname {{.ChangedFile}})"},
+		{"${TEST_EXPAND_HOME:-/tmp}", "${TEST_EXPAND_HOME:-/tmp}"},
+		{"$WATCHDIR/cmd", "$WATCHDIR/cmd"},
+		{"echo $BUILDERATOR_TEST_VAR", "echo $BUILDERATOR_TEST_VAR"},
+		{"trailing $", "trailing $"},
+		{"${unclosed", "${unclosed"},
+	}
+	for _, tc := range cases {
+		actual := ExpandConfigEnv(tc.in)
+		if actual != tc.expected {
+			t.Errorf("%q: expected %q, got %q", tc.in, tc.expected, actual)
+		}
+	}
+}
+
+func TestReadConfigExpandsEnv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "builderator-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("TEST_EXPAND_DIR", dir)
+	defer os.Unsetenv("TEST_EXPAND_DIR")
+	os.Setenv("TEST_EXPAND_TARGET", "all")
+	defer os.Unsetenv("TEST_EXPAND_TARGET")
+
+	cpath := filepath.Join(dir, "builderator.toml")
+	err = ioutil.WriteFile(cpath, []byte(`WatchDir = "$TEST_EXPAND_DIR"
+BuildArgv = ["make", "$TEST_EXPAND_TARGET", "$$TEST_EXPAND_TARGET"]
+BuildFile = ["${TEST_EXPAND_DIR}/bin/a"]
+PreBuildCmd = "kill -0 $$$$ && make $TEST_EXPAND_TARGET $${TEST_EXPAND_TARGET}"
+[[Task]]
+Name = "t"
+OnSuccessCmd = "echo $TEST_EXPAND_TARGET"
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs, err := ReadTasks(cpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := cs[0]
+	if c.WatchDir != dir {
+		t.Errorf("WatchDir read as %v", c.WatchDir)
+	}
+	if !reflect.DeepEqual(c.BuildArgv, []string{"make", "all", "$TEST_EXPAND_TARGET"}) {
+		t.Errorf("BuildArgv read as %q", c.BuildArgv)
+	}
+	if !reflect.DeepEqual(c.BuildFiles, []string{filepath.Join(dir, "bin/a")}) {
+		t.Errorf("BuildFile read as %q", c.BuildFiles)
+	}
+	if c.PreBuildCmd != "kill -0 $$ && make all ${TEST_EXPAND_TARGET}" {
+		t.Errorf("PreBuildCmd read as %q", c.PreBuildCmd)
+	}
+	if c.OnSuccessCmd != "echo all" {
+		t.Errorf("task OnSuccessCmd read as %q", c.OnSuccessCmd)
+	}
+}
@@ -0,0 +1,35 @@
+package engine
+
+import (
+	"strings"
+)
+
+// explainer logs what became of changed files on their way to a build, for Runner.Explain.
+// A nil explainer logs nothing.
+type explainer func(files []string, decision string)
+
+// The explainer for a config, or nil unless r.Explain is set.
+func (r *Runner) explainer(c Config) explainer {
+	if !r.Explain {
+		return nil
+	}
+	prefix := taskPrefix(c)
+	return func(files []string, decision string) {
+		rel := relChanged(c, files)
+		fields := logFields{"event": "explain", "changedFiles": rel, "decision": decision}
+		if c.Task != "" {
+			fields["task"] = c.Task
+		}
+		what := strings.Join(rel, ", ")
+		if len(files) == 0 {
+			what = "manual trigger"
+		}
+		logInfoFields(fields, "%v%v: %v", prefix, what, decision)
+	}
+}
+
+func (e explainer) explain(files []string, decision string) {
+	if e != nil {
+		e(files, decision)
+	}
+}
@@ -0,0 +1,121 @@
+package engine
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+	"syscall"
+)
+
+// FakeCommandRunner is a CommandRunner for tests that starts no processes.
+// Each command it starts runs until it is ended with Exit or by a signal.
+type FakeCommandRunner struct {
+	started chan *FakeCommand
+}
+
+// NewFakeCommandRunner returns a FakeCommandRunner that can have up to `buffer`
+// started commands waiting to be received from Started.
+func NewFakeCommandRunner(buffer int) *FakeCommandRunner {
+	return &FakeCommandRunner{started: make(chan *FakeCommand, buffer)}
+}
+
+// Started receives each command as it starts.
+func (f *FakeCommandRunner) Started() <-chan *FakeCommand {
+	return f.started
+}
+
+func (f *FakeCommandRunner) Start(cmd *exec.Cmd) (Command, error) {
+	fc := &FakeCommand{
+		Args:   cmd.Args,
+		Dir:    cmd.Dir,
+		Env:    cmd.Env,
+		stdout: cmd.Stdout,
+		stderr: cmd.Stderr,
+		done:   make(chan error, 1),
+	}
+	f.started <- fc
+	return fc, nil
+}
+
+// FakeCommand is a command started by a FakeCommandRunner.
+type FakeCommand struct {
+	Args []string
+	Dir  string
+	Env  []string
+
+	stdout io.Writer
+	stderr io.Writer
+	done   chan error
+
+	mu       sync.Mutex
+	exited   bool
+	stubborn bool
+	signals  []syscall.Signal
+}
+
+// Exit ends the command after writing its output. A nil err is a successful build.
+// Does nothing if it has already exited.
+func (fc *FakeCommand) Exit(stdout string, stderr string, err error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if fc.exited {
+		return
+	}
+	io.WriteString(fc.stdout, stdout)
+	io.WriteString(fc.stderr, stderr)
+	fc.exit(err)
+}
+
+// Output writes output without exiting, like a build that is still going.
+// Does nothing if it has already exited.
+func (fc *FakeCommand) Output(stdout string, stderr string) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if fc.exited {
+		return
+	}
+	io.WriteString(fc.stdout, stdout)
+	io.WriteString(fc.stderr, stderr)
+}
+
+// IgnoreSignals keeps the command running after any signal but SIGKILL,
+// like a build that is slow to clean up.
+func (fc *FakeCommand) IgnoreSignals() {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.stubborn = true
+}
+
+// Signals returns the signals sent to the command so far, not counting signal 0.
+func (fc *FakeCommand) Signals() []syscall.Signal {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return append([]syscall.Signal(nil), fc.signals...)
+}
+
+func (fc *FakeCommand) Wait() error {
+	return <-fc.done
+}
+
+func (fc *FakeCommand) Signal(sig syscall.Signal) error {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if fc.exited {
+		return fmt.Errorf("command has already exited")
+	}
+	if sig == 0 {
+		return nil
+	}
+	fc.signals = append(fc.signals, sig)
+	if !fc.stubborn || sig == syscall.SIGKILL {
+		fc.exit(fmt.Errorf("signal: %v", sig))
+	}
+	return nil
+}
+
+// Must hold mu.
+func (fc *FakeCommand) exit(err error) {
+	fc.exited = true
+	fc.done <- err
+}
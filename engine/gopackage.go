@@ -0,0 +1,82 @@
+package engine
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// GoPackageCmd when it isn't set.
+const DEFAULT_GO_PACKAGE_CMD = "go test {{.Package}}/..."
+
+// Changes to these rebuild everything even with GoPackageScoped.
+var goModuleFiles = []string{"go.mod", "go.sum", "go.work", "go.work.sum"}
+
+// Group changed .go files by the package directory they are in, relative to BuildCmdDir.
+// Returns the packages in the order they first changed, and their files.
+// Returns no packages when a module file changed, since that can affect every package.
+func goPackages(c Config, changedRel []string) ([]string, map[string][]string) {
+	var pkgs []string
+	files := make(map[string][]string)
+	for _, f := range changedRel {
+		base := filepath.Base(f)
+		for _, m := range goModuleFiles {
+			if base == m {
+				return nil, nil
+			}
+		}
+		if filepath.Ext(f) != ".go" {
+			continue
+		}
+		dir := filepath.Dir(f)
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(c.WatchDir, dir)
+		}
+		pkg, err := filepath.Rel(c.BuildCmdDir, dir)
+		if err != nil {
+			continue
+		}
+		pkg = filepath.ToSlash(pkg)
+		if pkg != "." && !strings.HasPrefix(pkg, "../") && pkg != ".." {
+			pkg = "./" + pkg
+		}
+		if _, ok := files[pkg]; !ok {
+			pkgs = append(pkgs, pkg)
+		}
+		files[pkg] = append(files[pkg], f)
+	}
+	return pkgs, files
+}
+
+// Render the bash command for a GoPackageScoped build: GoPackageCmd for each changed
+// package, each run only if the ones before it succeeded.
+// Returns ok false when the build should run BuildCmd or BuildArgv as usual.
+func renderGoPackageCmds(c Config, changedRel []string) (cmd string, ok bool, err error) {
+	if !c.GoPackageScoped || len(changedRel) == 0 {
+		return "", false, nil
+	}
+	pkgs, files := goPackages(c, changedRel)
+	if len(pkgs) == 0 {
+		return "", false, nil
+	}
+	t, err := template.New("GoPackageCmd").Parse(c.GoPackageCmd)
+	if err != nil {
+		return "", false, err
+	}
+	var cmds []string
+	for _, pkg := range pkgs {
+		var b bytes.Buffer
+		err := t.Execute(&b, BuildCmdContext{
+			ChangedFiles: files[pkg],
+			WatchDir:     c.WatchDir,
+			ConfigPath:   c.ConfigPath,
+			Package:      pkg,
+		})
+		if err != nil {
+			return "", false, err
+		}
+		cmds = append(cmds, "("+b.String()+")")
+	}
+	return strings.Join(cmds, " && "), true, nil
+}
@@ -0,0 +1,48 @@
+package engine
+
+import (
+	"testing"
+)
+
+func TestRenderGoPackageCmds(t *testing.T) {
+	c := Config{
+		WatchDir:        "/src",
+		BuildCmdDir:     "/src",
+		BuildCmd:        "go test ./...",
+		GoPackageScoped: true,
+		GoPackageCmd:    DEFAULT_GO_PACKAGE_CMD,
+	}
+	cases := []struct {
+		changed []string
+		cmd     string
+		ok      bool
+	}{
+		{nil, "", false},
+		{[]string{"README.md"}, "", false},
+		{[]string{"foo/bar/x.go"}, "(go test ./foo/bar/...)", true},
+		{[]string{"main.go", "README.md"}, "(go test ./...)", true},
+		// Each package runs once, in the order they changed.
+		{[]string{"b/y.go", "a/x.go", "b/y_test.go"}, "(go test ./b/...) && (go test ./a/...)", true},
+		{[]string{"a/x.go", "go.mod"}, "", false},
+		{[]string{"/elsewhere/lib/z.go"}, "(go test ../elsewhere/lib/...)", true},
+	}
+	for _, tc := range cases {
+		cmd, ok, err := renderGoPackageCmds(c, tc.changed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cmd != tc.cmd || ok != tc.ok {
+			t.Errorf("%q: got %q %v, expected %q %v", tc.changed, cmd, ok, tc.cmd, tc.ok)
+		}
+	}
+
+	c.BuildCmdDir = "/src/cmd"
+	c.GoPackageCmd = "go vet {{.Package}} # {{.ChangedFile}}"
+	cmd, _, err := renderGoPackageCmds(c, []string{"cmd/tool/main.go"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd != "(go vet ./tool # cmd/tool/main.go)" {
+		t.Errorf("rendered as %q", cmd)
+	}
+}
@@ -0,0 +1,46 @@
+package engine
+
+import (
+	"os"
+	"time"
+)
+
+const DEFAULT_HEARTBEAT_INTERVAL = 10 * time.Second
+
+// Write the time to HeartbeatFile every HeartbeatInterval,
+// so that a stale file means builderator has died.
+// Returns a func that stops the heartbeat and removes the file,
+// since a clean exit isn't a crash.
+func startHeartbeat(c Config) func() {
+	if c.HeartbeatFile == nil {
+		return func() {}
+	}
+	p := *c.HeartbeatFile
+	beat := func() {
+		err := writeFileAtomic(p, []byte(time.Now().Format(time.RFC3339)+"\n"), c.StatusFileMode)
+		if err != nil {
+			logWarn("could not write heartbeat file: %v", err)
+		}
+	}
+	beat()
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(c.HeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				beat()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+		os.Remove(p)
+	}
+}
@@ -0,0 +1,40 @@
+package engine
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHeartbeat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "builderator-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "heartbeat")
+	c := Config{HeartbeatFile: &p, HeartbeatInterval: 10 * time.Millisecond, StatusFileMode: 0644}
+
+	stop := startHeartbeat(c)
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		stop()
+		t.Fatal(err)
+	}
+	first, err := time.Parse(time.RFC3339, strings.TrimSpace(string(b)))
+	if err != nil {
+		stop()
+		t.Fatal(err)
+	}
+	if d := time.Since(first); d > time.Minute {
+		t.Errorf("heartbeat is %v old", d)
+	}
+
+	stop()
+	if _, err := os.Stat(p); !os.IsNotExist(err) {
+		t.Fatalf("heartbeat file left after stopping: %v", err)
+	}
+}
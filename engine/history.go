@@ -0,0 +1,33 @@
+package engine
+
+import "time"
+
+// HistoryEntry is a finished build as kept in the Runner's history.
+type HistoryEntry struct {
+	State           string    `json:"state"`
+	Error           string    `json:"error,omitempty"`
+	Finished        time.Time `json:"finished"`
+	DurationSeconds float64   `json:"duration_seconds"`
+}
+
+func NewHistoryEntry(res BuildResult, finished time.Time) HistoryEntry {
+	e := HistoryEntry{
+		State:           STATE_OK,
+		Finished:        finished,
+		DurationSeconds: res.Duration.Seconds(),
+	}
+	if res.Error != nil {
+		e.State = STATE_FAILED
+		e.Error = res.Error.Error()
+	}
+	return e
+}
+
+// Add an entry to a history, dropping the oldest beyond `size`.
+func appendHistory(history []HistoryEntry, e HistoryEntry, size int) []HistoryEntry {
+	history = append(history, e)
+	if len(history) > size {
+		history = append([]HistoryEntry(nil), history[len(history)-size:]...)
+	}
+	return history
+}
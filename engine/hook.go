@@ -0,0 +1,96 @@
+package engine
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"strings"
+	"text/template"
+)
+
+// OnStatusChangeContext is the data available to OnStatusChange as a text/template.
+type OnStatusChangeContext struct {
+	// One of the STATE_* values.
+	State      string
+	WatchDir   string
+	ConfigPath string
+}
+
+// Run OnStatusChange in the background if the state differs from the last one it ran for.
+func (r *Runner) runStatusHook(c Config, state string) {
+	if c.OnStatusChange == "" || state == r.hookState {
+		return
+	}
+	r.hookState = state
+	cmdStr, err := renderStatusHook(c, state)
+	if err != nil {
+		logWarn("could not render OnStatusChange: %v", err)
+		return
+	}
+	cmd := exec.Command("bash", "-c", cmdStr)
+	cmd.Dir = c.BuildCmdDir
+	cmd.Env = append(os.Environ(), "BUILDERATOR_STATE="+state)
+	err = cmd.Start()
+	if err != nil {
+		logWarn("could not run OnStatusChange: %v", err)
+		return
+	}
+	go func() {
+		err := cmd.Wait()
+		if err != nil {
+			logDebug("OnStatusChange for %v failed: %v", state, err)
+		}
+	}()
+}
+
+// Run OnSuccessCmd in the background when a build succeeds and the last one
+// to run to completion didn't, logging its output.
+// A failing OnSuccessCmd is logged but doesn't change the build's outcome.
+func (r *Runner) runSuccessHook(c Config, res BuildResult) {
+	prev := r.lastOutcome
+	if res.Error != nil {
+		r.lastOutcome = STATE_FAILED
+		return
+	}
+	r.lastOutcome = STATE_OK
+	if c.OnSuccessCmd == "" || prev == STATE_OK {
+		return
+	}
+	prefix := taskPrefix(c)
+	cmd := exec.Command("bash", "-c", c.OnSuccessCmd)
+	cmd.Dir = c.BuildCmdDir
+	go func() {
+		logInfo("%vrunning OnSuccessCmd", prefix)
+		out, err := cmd.CombinedOutput()
+		for _, line := range strings.Split(strings.TrimRight(string(out), "\n"), "\n") {
+			if line != "" {
+				logInfo("%vOnSuccessCmd: %v", prefix, line)
+			}
+		}
+		if err != nil {
+			logWarn("%vOnSuccessCmd failed: %v", prefix, err)
+		}
+	}()
+}
+
+// Render OnStatusChange as a text/template with an OnStatusChangeContext.
+// Commands without any template actions are returned untouched.
+func renderStatusHook(c Config, state string) (string, error) {
+	if !strings.Contains(c.OnStatusChange, "{{") {
+		return c.OnStatusChange, nil
+	}
+	t, err := template.New("OnStatusChange").Parse(c.OnStatusChange)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	err = t.Execute(&b, OnStatusChangeContext{
+		State:      state,
+		WatchDir:   c.WatchDir,
+		ConfigPath: c.ConfigPath,
+	})
+	if err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
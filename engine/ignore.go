@@ -0,0 +1,210 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// IGNORE_FILE_NAME is read from next to the config file for patterns of changes to never build on.
+const IGNORE_FILE_NAME = ".builderatorignore"
+
+// IgnoreRules are gitignore style patterns for changes that don't trigger builds.
+type IgnoreRules struct {
+	// Absolute path to the file the patterns are from. Patterns are relative to its directory.
+	Path string
+	// The patterns as written, without blank lines and comments.
+	Patterns []string
+
+	rules []ignoreRule
+}
+
+type ignoreRule struct {
+	re *regexp.Regexp
+	// Re-include what earlier patterns ignored, for patterns starting with "!".
+	negate bool
+	// Only match directories, for patterns ending in "/".
+	dirOnly bool
+}
+
+// Read the ignore file at p. Returns nil without an error if it doesn't exist.
+func readIgnoreFile(p string) (*IgnoreRules, error) {
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	ig, err := parseIgnore(f, p)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", p, err)
+	}
+	return ig, nil
+}
+
+// Parse gitignore style lines read from the file at p.
+// Blank lines and lines starting with # are skipped.
+// Supports "!" negation, a trailing "/" for directories, a leading or inner "/" to anchor
+// to the file's directory, "*", "?", "[...]" and "**".
+func parseIgnore(r io.Reader, p string) (*IgnoreRules, error) {
+	ig := &IgnoreRules{Path: p}
+	scanner := bufio.NewScanner(r)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, err := compileIgnoreRule(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %v", lineno, err)
+		}
+		ig.Patterns = append(ig.Patterns, line)
+		ig.rules = append(ig.rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ig, nil
+}
+
+func compileIgnoreRule(pattern string) (ignoreRule, error) {
+	var rule ignoreRule
+	if strings.HasPrefix(pattern, "!") {
+		rule.negate = true
+		pattern = pattern[1:]
+	} else if strings.HasPrefix(pattern, `\`) {
+		// Escapes a leading "!" or "#".
+		pattern = pattern[1:]
+	}
+	if strings.HasSuffix(pattern, "/") {
+		rule.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+	if pattern == "" {
+		return rule, fmt.Errorf("empty pattern")
+	}
+	// Patterns with a slash are relative to the ignore file, others match at any depth.
+	anchored := strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+
+	var b bytes.Buffer
+	b.WriteString("^")
+	if !anchored {
+		b.WriteString("(?:.*/)?")
+	}
+	for i := 0; i < len(pattern); i++ {
+		ch := pattern[i]
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case ch == '*':
+			b.WriteString("[^/]*")
+		case ch == '?':
+			b.WriteString("[^/]")
+		case ch == '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				b.WriteString(regexp.QuoteMeta("["))
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.Replace(class, `\`, `\\`, -1) + "]")
+			i += end + 1
+		case ch == '\\' && i+1 < len(pattern):
+			b.WriteString(regexp.QuoteMeta(pattern[i+1 : i+2]))
+			i++
+		default:
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	b.WriteString("$")
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return rule, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+	}
+	rule.re = re
+	return rule, nil
+}
+
+// Ignored reports whether changes to the absolute path p are ignored.
+// Everything inside an ignored directory is ignored too.
+// Paths outside the ignore file's directory are never ignored.
+func (ig *IgnoreRules) Ignored(p string) bool {
+	ignored, _ := ig.IgnoredBy(p)
+	return ignored
+}
+
+// IgnoredBy is Ignored, also returning the pattern that decided it,
+// which may be a "!" pattern that re-included p. "" if no pattern matched.
+func (ig *IgnoreRules) IgnoredBy(p string) (bool, string) {
+	if ig == nil {
+		return false, ""
+	}
+	rel, err := filepath.Rel(filepath.Dir(ig.Path), p)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return false, ""
+	}
+	rel = filepath.ToSlash(rel)
+	parts := strings.Split(rel, "/")
+	for i := 1; i < len(parts); i++ {
+		if ignored, pattern := ig.match(strings.Join(parts[:i], "/"), true); ignored {
+			return true, pattern
+		}
+	}
+	info, err := os.Stat(p)
+	return ig.match(rel, err == nil && info.IsDir())
+}
+
+// Whether the last pattern matching rel ignores it, and that pattern.
+func (ig *IgnoreRules) match(rel string, isDir bool) (bool, string) {
+	ignored := false
+	pattern := ""
+	for i, rule := range ig.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.re.MatchString(rel) {
+			ignored = !rule.negate
+			pattern = ig.Patterns[i]
+		}
+	}
+	return ignored, pattern
+}
+
+// Filter returns the paths that aren't ignored.
+func (ig *IgnoreRules) Filter(paths []string) []string {
+	if ig == nil {
+		return paths
+	}
+	var kept []string
+	for _, p := range paths {
+		if !ig.Ignored(p) {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
+
+// Equal reports whether two sets of rules are from the same file with the same patterns.
+func (ig *IgnoreRules) Equal(other *IgnoreRules) bool {
+	if ig == nil || other == nil {
+		return ig == other
+	}
+	return ig.Path == other.Path && strings.Join(ig.Patterns, "\n") == strings.Join(other.Patterns, "\n")
+}
@@ -0,0 +1,123 @@
+package engine
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIgnored(t *testing.T) {
+	dir, err := ioutil.TempDir("", "builderator-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(filepath.Join(dir, "src", "gen"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	in := `# generated
+*.log
+/build
+gen/
+docs/**/*.md
+!keep.log
+\#literal
+file[0-9].txt
+`
+	ig, err := parseIgnore(strings.NewReader(in), filepath.Join(dir, IGNORE_FILE_NAME))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ig.Patterns) != 7 {
+		t.Fatalf("expected 7 patterns, got %q", ig.Patterns)
+	}
+
+	cases := []struct {
+		rel     string
+		ignored bool
+	}{
+		{"main.go", false},
+		{"out.log", true},
+		{"src/deep/out.log", true},
+		{"keep.log", false},
+		{"src/keep.log", false},
+		{"build", true},
+		{"build/out.o", true},
+		{"src/build/out.o", false},
+		{"src/gen", true},
+		{"src/gen/x.go", true},
+		{"gen", false},
+		{"docs/a.md", true},
+		{"docs/x/y/a.md", true},
+		{"docs/a.txt", false},
+		{"#literal", true},
+		{"file1.txt", true},
+		{"fileA.txt", false},
+		{"../outside.log", false},
+	}
+	for _, tc := range cases {
+		p := filepath.Join(dir, tc.rel)
+		if ig.Ignored(p) != tc.ignored {
+			t.Errorf("%v: expected ignored %v", tc.rel, tc.ignored)
+		}
+	}
+
+	kept := ig.Filter([]string{filepath.Join(dir, "main.go"), filepath.Join(dir, "out.log")})
+	if len(kept) != 1 || kept[0] != filepath.Join(dir, "main.go") {
+		t.Fatalf("unexpected filter result: %q", kept)
+	}
+
+	for rel, pattern := range map[string]string{
+		"main.go":        "",
+		"out.log":        "*.log",
+		"keep.log":       "!keep.log",
+		"src/gen/x.go":   "gen/",
+		"build/out.o":    "/build",
+		"../outside.log": "",
+	} {
+		_, actual := ig.IgnoredBy(filepath.Join(dir, rel))
+		if actual != pattern {
+			t.Errorf("%v: expected decided by %q, got %q", rel, pattern, actual)
+		}
+	}
+}
+
+func TestReadIgnoreFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "builderator-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, IGNORE_FILE_NAME)
+
+	ig, err := readIgnoreFile(p)
+	if err != nil || ig != nil {
+		t.Fatalf("expected no rules for a missing file, got %v %v", ig, err)
+	}
+
+	if err := ioutil.WriteFile(p, []byte("*.tmp\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ig, err = readIgnoreFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	same, _ := parseIgnore(strings.NewReader("# same\n*.tmp\n"), p)
+	if !ig.Equal(same) {
+		t.Fatalf("expected %q to equal %q", ig.Patterns, same.Patterns)
+	}
+	var none *IgnoreRules
+	if ig.Equal(none) || !none.Equal(nil) {
+		t.Fatal("unexpected Equal result with nil rules")
+	}
+
+	if err := ioutil.WriteFile(p, []byte("ok\n!\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readIgnoreFile(p); err == nil {
+		t.Fatal("expected an error for an empty negated pattern")
+	}
+}
@@ -0,0 +1,132 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Log levels, from quietest to most verbose.
+const (
+	// Only warnings and build failures.
+	LOG_QUIET = iota
+	LOG_INFO
+	// Also watcher events, build lifecycle and status bar sends.
+	LOG_DEBUG
+)
+
+// Log formats.
+const (
+	// Human readable lines.
+	LOG_FORMAT_TEXT = "text"
+	// One JSON object per line with "ts", "level", "msg" and event fields.
+	LOG_FORMAT_JSON = "json"
+)
+
+var logLevel = LOG_INFO
+var logFormat = LOG_FORMAT_TEXT
+
+// Keeps json lines whole when logging from several goroutines.
+var logMu sync.Mutex
+
+// SetLogLevel sets how much is logged. Call it before Run.
+func SetLogLevel(level int) {
+	logLevel = level
+}
+
+// LogLevel is the level set by SetLogLevel.
+func LogLevel() int {
+	return logLevel
+}
+
+// SetLogFormat sets how log lines look, LOG_FORMAT_TEXT or LOG_FORMAT_JSON. Call it before Run.
+func SetLogFormat(format string) error {
+	switch format {
+	case LOG_FORMAT_TEXT, LOG_FORMAT_JSON:
+		logFormat = format
+		return nil
+	}
+	return fmt.Errorf("unknown log format %q: must be %q or %q", format, LOG_FORMAT_TEXT, LOG_FORMAT_JSON)
+}
+
+// LogFormat is the format set by SetLogFormat.
+func LogFormat() string {
+	return logFormat
+}
+
+// logFields are details of an event for json logs. Text logs leave them out.
+type logFields map[string]interface{}
+
+// Write one log line. `prefix` marks the level in text logs.
+func logLine(level string, prefix string, fields logFields, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	logMu.Lock()
+	defer logMu.Unlock()
+	if logFormat != LOG_FORMAT_JSON {
+		fmt.Print(prefix + msg + "\n")
+		return
+	}
+	entry := map[string]interface{}{}
+	for k, v := range fields {
+		entry[k] = v
+	}
+	entry["ts"] = time.Now().Format(time.RFC3339Nano)
+	entry["level"] = level
+	entry["msg"] = strings.TrimRight(msg, "\n")
+	b, err := json.Marshal(entry)
+	if err != nil {
+		b, _ = json.Marshal(map[string]string{"level": level, "msg": msg})
+	}
+	fmt.Println(string(b))
+}
+
+func logDebug(format string, args ...interface{}) {
+	logDebugFields(nil, format, args...)
+}
+
+func logDebugFields(fields logFields, format string, args ...interface{}) {
+	if logLevel >= LOG_DEBUG {
+		logLine("debug", "debug: ", fields, format, args...)
+	}
+}
+
+func logInfo(format string, args ...interface{}) {
+	logInfoFields(nil, format, args...)
+}
+
+func logInfoFields(fields logFields, format string, args ...interface{}) {
+	if logLevel >= LOG_INFO {
+		logLine("info", "", fields, format, args...)
+	}
+}
+
+// For problems the user should see even with LOG_QUIET.
+func logWarn(format string, args ...interface{}) {
+	logLine("warn", "WARN: ", nil, format, args...)
+}
+
+// For build failures, shown even with LOG_QUIET.
+func logError(format string, args ...interface{}) {
+	logErrorFields(nil, format, args...)
+}
+
+func logErrorFields(fields logFields, format string, args ...interface{}) {
+	logLine("error", "", fields, format, args...)
+}
+
+// LogInfo logs like the engine does, at LOG_INFO and in the LogFormat.
+func LogInfo(format string, args ...interface{}) {
+	logInfo(format, args...)
+}
+
+// LogWarn logs a problem like the engine does, even with LOG_QUIET.
+func LogWarn(format string, args ...interface{}) {
+	logWarn(format, args...)
+}
+
+// LogError logs a failure like the engine does, even with LOG_QUIET.
+func LogError(format string, args ...interface{}) {
+	logError(format, args...)
+}
@@ -0,0 +1,96 @@
+package engine
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Upper bounds in seconds of the build duration histogram's buckets.
+var metricsDurationBuckets = []float64{0.5, 1, 2, 5, 10, 30, 60, 120, 300, 600}
+
+// buildMetrics are a Runner's Prometheus metrics, for GET /metrics.
+// Labeled with the task if there is one. Safe to use from any goroutine.
+type buildMetrics struct {
+	registry *prometheus.Registry
+	builds   prometheus.Counter
+	failures prometheus.Counter
+	duration prometheus.Histogram
+	state    *prometheus.GaugeVec
+}
+
+func newBuildMetrics(task string) *buildMetrics {
+	var labels prometheus.Labels
+	if task != "" {
+		labels = prometheus.Labels{"task": task}
+	}
+	m := &buildMetrics{
+		registry: prometheus.NewRegistry(),
+		builds: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "builderator_builds_total",
+			Help:        "Builds that ran to completion.",
+			ConstLabels: labels,
+		}),
+		failures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "builderator_build_failures_total",
+			Help:        "Builds that ran to completion and failed.",
+			ConstLabels: labels,
+		}),
+		duration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:        "builderator_build_duration_seconds",
+			Help:        "How long builds that ran to completion took.",
+			ConstLabels: labels,
+			Buckets:     metricsDurationBuckets,
+		}),
+		state: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "builderator_state",
+			Help:        "1 for the current state, 0 for the others.",
+			ConstLabels: labels,
+		}, []string{"state"}),
+	}
+	m.registry.MustRegister(
+		m.builds, m.failures, m.duration, m.state,
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		prometheus.NewGoCollector(),
+	)
+	m.setState(STATE_IDLE)
+	return m
+}
+
+func (m *buildMetrics) observe(res BuildResult) {
+	m.builds.Inc()
+	if res.Error != nil {
+		m.failures.Inc()
+	}
+	m.duration.Observe(res.Duration.Seconds())
+}
+
+func (m *buildMetrics) setState(state string) {
+	// One series for each state.
+	for _, s := range statusStates {
+		v := 0.0
+		if s == state {
+			v = 1
+		}
+		m.state.WithLabelValues(s).Set(v)
+	}
+}
+
+// Start the metrics server on ControlHost:MetricsPort.
+// GET /metrics returns build counts, durations and the current state for Prometheus.
+// Returns quick.
+func (r *Runner) serveMetrics(c Config) (*http.Server, error) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(r.metrics.registry, promhttp.HandlerOpts{}))
+
+	ln, err := net.Listen("tcp", net.JoinHostPort(c.ControlHost, strconv.Itoa(c.MetricsPort)))
+	if err != nil {
+		return nil, err
+	}
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(ln)
+	return srv, nil
+}
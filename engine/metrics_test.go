@@ -0,0 +1,59 @@
+package engine
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func TestMetrics(t *testing.T) {
+	scrape := func(m *buildMetrics) string {
+		srv := httptest.NewServer(promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+		defer srv.Close()
+		resp, err := srv.Client().Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	m := newBuildMetrics("web")
+	m.observe(BuildResult{Duration: 800 * time.Millisecond})
+	m.observe(BuildResult{Error: fmt.Errorf("exit status 1"), Duration: 3 * time.Second})
+	m.setState(STATE_FAILED)
+	out := scrape(m)
+	for _, line := range []string{
+		`builderator_builds_total{task="web"} 2`,
+		`builderator_build_failures_total{task="web"} 1`,
+		`builderator_build_duration_seconds_bucket{task="web",le="0.5"} 0`,
+		`builderator_build_duration_seconds_bucket{task="web",le="1"} 1`,
+		`builderator_build_duration_seconds_bucket{task="web",le="5"} 2`,
+		`builderator_build_duration_seconds_bucket{task="web",le="+Inf"} 2`,
+		`builderator_build_duration_seconds_sum{task="web"} 3.8`,
+		`builderator_build_duration_seconds_count{task="web"} 2`,
+		`builderator_state{state="ok",task="web"} 0`,
+		`builderator_state{state="failed",task="web"} 1`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing %v in:\n%v", line, out)
+		}
+	}
+	if !strings.Contains(out, "go_goroutines ") {
+		t.Errorf("missing the Go collector in:\n%v", out)
+	}
+
+	out = scrape(newBuildMetrics(""))
+	if !strings.Contains(out, "builderator_builds_total 0\n") || !strings.Contains(out, `builderator_state{state="idle"} 1`) {
+		t.Errorf("unexpected metrics without a task:\n%v", out)
+	}
+}
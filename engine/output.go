@@ -0,0 +1,114 @@
+package engine
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"sync"
+)
+
+const (
+	// Prefix for each line of build output streamed to the terminal.
+	STREAM_PREFIX = "| "
+)
+
+// Terminal escape sequences: CSI like colors and cursor movement,
+// OSC like window titles, and the remaining two byte escapes.
+var ansiPattern = regexp.MustCompile(`\x1b(?:\[[0-?]*[ -/]*[@-~]|\][^\x07\x1b]*(?:\x07|\x1b\\)|[0-~])`)
+
+// Remove terminal escape sequences like colors from s.
+func stripANSI(s string) string {
+	return ansiPattern.ReplaceAllString(s, "")
+}
+
+// tailBuffer is a writer that keeps only the last `limit` bytes written to it.
+type tailBuffer struct {
+	limit     int
+	buf       []byte
+	truncated bool
+}
+
+func newTailBuffer(limit int) *tailBuffer {
+	return &tailBuffer{limit: limit}
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p...)
+	if over := len(b.buf) - b.limit; over > 0 {
+		b.buf = b.buf[over:]
+		b.truncated = true
+	}
+	return len(p), nil
+}
+
+// String returns the kept output, noting if anything was dropped.
+func (b *tailBuffer) String() string {
+	if b.truncated {
+		return "(output truncated)\n" + string(b.buf)
+	}
+	return string(b.buf)
+}
+
+// progressBuffer is a tailBuffer that can be read while a build writes to it.
+type progressBuffer struct {
+	mu  sync.Mutex
+	buf *tailBuffer
+}
+
+func newProgressBuffer(limit int) *progressBuffer {
+	return &progressBuffer{buf: newTailBuffer(limit)}
+}
+
+func (b *progressBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *progressBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// lineStreamer writes complete lines to `out` with a prefix as they arrive,
+// or logs each as an output event with LOG_FORMAT_JSON.
+// Lines are written under the log mutex, so they never interleave with each other or with logs.
+type lineStreamer struct {
+	out    io.Writer
+	prefix string
+	// "stdout" or "stderr", for json logs.
+	stream string
+	buf    []byte
+}
+
+func (w *lineStreamer) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.writeLine(w.buf[:i+1])
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush writes out any trailing partial line.
+func (w *lineStreamer) Flush() {
+	if len(w.buf) > 0 {
+		w.writeLine(append(w.buf, '\n'))
+		w.buf = nil
+	}
+}
+
+func (w *lineStreamer) writeLine(line []byte) {
+	if logFormat == LOG_FORMAT_JSON {
+		logInfoFields(logFields{"event": "output", "stream": w.stream}, "%s", bytes.TrimRight(line, "\n"))
+		return
+	}
+	logMu.Lock()
+	defer logMu.Unlock()
+	w.out.Write(append([]byte(w.prefix), line...))
+}
@@ -0,0 +1,70 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTailBufferKeepsLimit(t *testing.T) {
+	b := newTailBuffer(10)
+	for i := 0; i < 7; i++ {
+		_, err := b.Write([]byte("abc"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(b.buf) != 10 {
+		t.Fatalf("kept %v bytes, expected 10", len(b.buf))
+	}
+	expected := "(output truncated)\ncabcabcabc"
+	if b.String() != expected {
+		t.Fatalf("got %q, expected %q", b.String(), expected)
+	}
+}
+
+func TestTailBufferLargeWrite(t *testing.T) {
+	b := newTailBuffer(1024)
+	_, err := b.Write([]byte(strings.Repeat("x", 4096) + "end"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b.buf) != 1024 {
+		t.Fatalf("kept %v bytes, expected 1024", len(b.buf))
+	}
+	if !strings.HasPrefix(b.String(), "(output truncated)\n") || !strings.HasSuffix(b.String(), "end") {
+		t.Fatalf("unexpected tail %q", b.String())
+	}
+}
+
+func TestTailBufferUnderLimit(t *testing.T) {
+	b := newTailBuffer(1024)
+	b.Write([]byte("hello\n"))
+	b.Write([]byte("world\n"))
+	if b.String() != "hello\nworld\n" {
+		t.Fatalf("got %q", b.String())
+	}
+}
+
+func TestStripANSI(t *testing.T) {
+	cases := []struct {
+		in       string
+		expected string
+	}{
+		{"plain text\n", "plain text\n"},
+		{"\x1b[31merror\x1b[0m: bad", "error: bad"},
+		{"\x1b[1;33mwarn\x1b[m", "warn"},
+		{"\x1b[38;5;196mred\x1b[39m", "red"},
+		{"\x1b[2K\x1b[1Gprogress", "progress"},
+		{"\x1b[?25lhidden cursor\x1b[?25h", "hidden cursor"},
+		{"\x1b]0;title\x07after", "after"},
+		{"\x1b]8;;http://x\x1b\\link\x1b]8;;\x1b\\", "link"},
+		{"\x1b7saved\x1b8", "saved"},
+		{"multi\x1b[0m\nline\x1b[32m ok\x1b[0m\n", "multi\nline ok\n"},
+	}
+	for _, tc := range cases {
+		out := stripANSI(tc.in)
+		if out != tc.expected {
+			t.Errorf("stripANSI(%q) = %q, expected %q", tc.in, out, tc.expected)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package engine
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestWhich(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fixtures rely on mode bits")
+	}
+	dir, err := ioutil.TempDir("", "builderator-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	first := filepath.Join(dir, "first")
+	second := filepath.Join(dir, "second")
+	for _, d := range []string{first, second, filepath.Join(first, "tool")} {
+		err := os.Mkdir(d, 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	fixtures := []struct {
+		path string
+		mode os.FileMode
+	}{
+		// first/tool is a directory, so this is the one found.
+		{filepath.Join(second, "tool"), 0755},
+		{filepath.Join(first, "script"), 0755},
+		{filepath.Join(second, "script"), 0755},
+		{filepath.Join(first, "data"), 0644},
+	}
+	for _, f := range fixtures {
+		err := ioutil.WriteFile(f.path, []byte("#!/bin/sh\n"), f.mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	oldPath := os.Getenv("PATH")
+	defer os.Setenv("PATH", oldPath)
+	os.Setenv("PATH", first+string(os.PathListSeparator)+filepath.Join(dir, "missing")+string(os.PathListSeparator)+second)
+
+	cases := []struct {
+		name     string
+		expected string
+	}{
+		{"tool", filepath.Join(second, "tool")},
+		{"script", filepath.Join(first, "script")},
+		{"data", ""},
+		{"nothing", ""},
+		{filepath.Join(second, "script"), filepath.Join(second, "script")},
+	}
+	for _, tc := range cases {
+		p, err := which(tc.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		if p != nil {
+			got = *p
+		}
+		if got != tc.expected {
+			t.Errorf("%v => %q, expected %q", tc.name, got, tc.expected)
+		}
+	}
+}
+
+func TestExecutableNames(t *testing.T) {
+	cases := []struct {
+		name     string
+		goos     string
+		pathext  string
+		expected []string
+	}{
+		{"fswatch", "linux", ".EXE", []string{"fswatch"}},
+		{"justasec", "windows", ".COM;.EXE", []string{"justasec.com", "justasec.exe"}},
+		{"justasec.EXE", "windows", ".COM;.EXE", []string{"justasec.EXE"}},
+		{"bash", "windows", "", []string{"bash.com", "bash.exe", "bash.bat", "bash.cmd"}},
+	}
+	for _, tc := range cases {
+		got := executableNames(tc.name, tc.goos, tc.pathext)
+		if !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("%v on %v => %v, expected %v", tc.name, tc.goos, got, tc.expected)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// Watch a directory or file by walking it every `interval`.
+// For filesystems that don't deliver change events (NFS, some containers).
+// Sends the changed paths into the `ch` whenever a walk finds differences.
+// Returns quick, with a func that stops the watcher.
+func pollWatch(ch chan<- []string, watchPath string, interval time.Duration) (func(), error) {
+	prev, err := pollSnapshot(watchPath)
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			next, err := pollSnapshot(watchPath)
+			if err != nil {
+				logWarn("could not poll %v: %v", watchPath, err)
+				continue
+			}
+			files := pollDiff(prev, next)
+			prev = next
+			if len(files) == 0 {
+				continue
+			}
+			logDebug("poll %v: %v changed", watchPath, len(files))
+			select {
+			case ch <- files:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	stop := func() {
+		close(done)
+	}
+	return stop, nil
+}
+
+func pollSnapshot(watchPath string) (map[string]fileStamp, error) {
+	snap := make(map[string]fileStamp)
+	err := filepath.Walk(watchPath, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Files may vanish mid-walk.
+			if os.IsNotExist(err) {
+				return nil
+			}
+			// Skip what can't be read rather than lose every other change.
+			logDebug("poll %v: skipping %v: %v", watchPath, p, err)
+			if info != nil && info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+		snap[p] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+		return nil
+	})
+	return snap, err
+}
+
+// Paths that were added, removed, or modified between two snapshots.
+func pollDiff(prev, next map[string]fileStamp) []string {
+	var files []string
+	for p, stamp := range next {
+		old, ok := prev[p]
+		if !ok || !old.modTime.Equal(stamp.modTime) || old.size != stamp.size {
+			files = append(files, p)
+		}
+	}
+	for p := range prev {
+		if _, ok := next[p]; !ok {
+			files = append(files, p)
+		}
+	}
+	sort.Strings(files)
+	return files
+}
@@ -0,0 +1,152 @@
+package engine
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/creack/pty"
+)
+
+// Terminal size for builds under a pty when builderator's stdout isn't a terminal to copy.
+const (
+	PTY_DEFAULT_ROWS = 24
+	PTY_DEFAULT_COLS = 80
+)
+
+// How long to keep copying output after the build exits, for a background
+// child still holding the pty open, before giving up on the rest.
+const PTY_DRAIN_TIMEOUT = 100 * time.Millisecond
+
+// ptyCommandRunner starts commands with another CommandRunner attached to a pseudo-terminal,
+// so tools that check for a terminal still print colors and progress.
+// Both stdout and stderr go to cmd.Stdout, a pty has just one output.
+type ptyCommandRunner struct {
+	runner CommandRunner
+}
+
+func (p ptyCommandRunner) Start(cmd *exec.Cmd) (Command, error) {
+	master, slave, err := pty.Open()
+	if err != nil {
+		return nil, fmt.Errorf("could not open a pty: %v", err)
+	}
+	// Keep output lines ending in \n rather than \r\n.
+	if err := disableONLCR(slave); err != nil {
+		logDebug("could not set pty output mode: %v", err)
+	}
+	resizePTY(master)
+
+	out := &cutoffWriter{w: cmd.Stdout}
+	cmd.Stdin = slave
+	cmd.Stdout = slave
+	cmd.Stderr = slave
+	// A new session with the pty as its controlling terminal.
+	// That is a new process group too, which aborting signals.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+	proc, err := p.runner.Start(cmd)
+	// The build has its own copy, and reads only end once every copy is closed.
+	slave.Close()
+	if err != nil {
+		master.Close()
+		return nil, err
+	}
+
+	copied := make(chan struct{})
+	go func() {
+		// Ends with an error (EIO on Linux) once the build and everything it started exit.
+		io.Copy(out, master)
+		master.Close()
+		close(copied)
+	}()
+
+	// Follow builderator's terminal as it is resized.
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-winch:
+				resizePTY(master)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return &ptyCommand{Command: proc, out: out, copied: copied, stop: func() {
+		signal.Stop(winch)
+		close(done)
+	}}, nil
+}
+
+type ptyCommand struct {
+	Command
+	out    *cutoffWriter
+	copied <-chan struct{}
+	stop   func()
+}
+
+// Wait for the build to exit and for its output to be copied out of the pty.
+// Waits at most PTY_DRAIN_TIMEOUT for the output once the build has exited.
+// After that the copy carries on until the pty closes, but its output is dropped.
+func (p *ptyCommand) Wait() error {
+	err := p.Command.Wait()
+	select {
+	case <-p.copied:
+	case <-time.After(PTY_DRAIN_TIMEOUT):
+		logDebug("a process the build started still holds its pty, not waiting for more output")
+		p.out.cut()
+	}
+	p.stop()
+	return err
+}
+
+// cutoffWriter writes to w until it is cut off, then drops everything.
+type cutoffWriter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	cutOff bool
+}
+
+func (c *cutoffWriter) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cutOff {
+		return len(b), nil
+	}
+	return c.w.Write(b)
+}
+
+// Drop all writes from now on. Returns once any write in progress is done.
+func (c *cutoffWriter) cut() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cutOff = true
+}
+
+// Size the pty like builderator's terminal, or PTY_DEFAULT_ROWS by PTY_DEFAULT_COLS.
+func resizePTY(master *os.File) {
+	ws := &pty.Winsize{Rows: PTY_DEFAULT_ROWS, Cols: PTY_DEFAULT_COLS}
+	if stdoutIsTerminal() {
+		cur, err := pty.GetsizeFull(os.Stdout)
+		if err == nil && cur.Rows > 0 && cur.Cols > 0 {
+			ws = cur
+		}
+	}
+	if err := pty.Setsize(master, ws); err != nil {
+		logDebug("could not set pty size: %v", err)
+	}
+}
+
+// Stop the terminal turning \n into \r\n in output.
+func disableONLCR(tty *os.File) error {
+	cmd := exec.Command("stty", "-onlcr")
+	cmd.Stdin = tty
+	return cmd.Run()
+}
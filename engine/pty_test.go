@@ -0,0 +1,94 @@
+package engine
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/creack/pty"
+)
+
+func skipWithoutPTY(t *testing.T) {
+	master, slave, err := pty.Open()
+	if err != nil {
+		t.Skipf("no pty support: %v", err)
+	}
+	master.Close()
+	slave.Close()
+}
+
+func TestBuildUsePTY(t *testing.T) {
+	skipWithoutPTY(t)
+	dir, err := ioutil.TempDir("", "builderator-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := Config{
+		WatchDir:        dir,
+		BuildCmd:        `test -t 1 && echo tty; echo err >&2; exit 3`,
+		BuildCmdDir:     dir,
+		UsePTY:          true,
+		MaxOutputBytes:  DEFAULT_MAX_OUTPUT_BYTES,
+		KillSignal:      DEFAULT_KILL_SIGNAL,
+		KillGracePeriod: time.Second,
+	}
+	var res BuildResult
+	select {
+	case res = <-build(context.Background(), c, nil, nil, nil, nil):
+	case <-time.After(10 * time.Second):
+		t.Fatal("build under a pty never finished")
+	}
+	if res.Error == nil || res.Error.Error() != "exit status 3" {
+		t.Errorf("unexpected error %v", res.Error)
+	}
+	if res.Stdout != "tty\nerr\n" || res.Stderr != "" {
+		t.Errorf("unexpected output %q %q", res.Stdout, res.Stderr)
+	}
+}
+
+func TestBuildUsePTYAbort(t *testing.T) {
+	skipWithoutPTY(t)
+	c := Config{
+		BuildCmd:        "sleep 30",
+		UsePTY:          true,
+		MaxOutputBytes:  DEFAULT_MAX_OUTPUT_BYTES,
+		KillSignal:      DEFAULT_KILL_SIGNAL,
+		KillGracePeriod: time.Second,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	resultCh := build(ctx, c, nil, nil, nil, nil)
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	select {
+	case res := <-resultCh:
+		if res.Error == nil {
+			t.Error("expected the aborted build to fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("build under a pty was not aborted")
+	}
+}
+
+func TestBuildUsePTYBackgroundChild(t *testing.T) {
+	skipWithoutPTY(t)
+	c := Config{
+		// The child outlives the build, and the hangup when it ends, holding the pty open.
+		BuildCmd:        `echo built; (trap "" HUP; sleep 2) & sleep 0.1`,
+		UsePTY:          true,
+		MaxOutputBytes:  DEFAULT_MAX_OUTPUT_BYTES,
+		KillSignal:      DEFAULT_KILL_SIGNAL,
+		KillGracePeriod: time.Second,
+	}
+	select {
+	case res := <-build(context.Background(), c, nil, nil, nil, nil):
+		if res.Error != nil || res.Stdout != "built\n" {
+			t.Errorf("unexpected result %v %q", res.Error, res.Stdout)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("build under a pty waited for its background child")
+	}
+}
@@ -0,0 +1,96 @@
+package engine
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// RemoteConfig runs builds on another machine over SSH.
+type RemoteConfig struct {
+	Host string
+	// Login user, or "" for ssh's default.
+	User string
+	// Where WatchDir is copied to on Host and BuildCmd runs.
+	RemoteDir string
+}
+
+type rawRemoteConfig struct {
+	Host      *string
+	User      *string
+	RemoteDir *string
+}
+
+// The ssh destination, like user@host.
+func (r RemoteConfig) target() string {
+	if r.User == "" {
+		return r.Host
+	}
+	return r.User + "@" + r.Host
+}
+
+// A name for a remote build, with a random part so its pid file can't be
+// mistaken for one a crashed earlier builderator with the same pid left behind.
+func nextRemoteBuildName() string {
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		logWarn("could not make a random remote build name: %v", err)
+	}
+	return nextBuildName() + "-" + hex.EncodeToString(nonce)
+}
+
+// Where a remote build records its process group so it can be killed.
+// The build removes it when it exits.
+func remotePidFile(name string) string {
+	return "/tmp/" + name + ".pid"
+}
+
+// The command to copy WatchDir to RemoteDir then run buildCmd there.
+// `name` identifies the build for remoteKill.
+// `env` is KEY=VALUE entries to set there, like BuildEnvFile's and BUILDERATOR_CHANGED_FILES.
+func remoteCommand(c Config, name string, buildCmd string, env []string) *exec.Cmd {
+	r := c.Remote
+	pidFile := shellQuote(remotePidFile(name))
+	// The build gets its own session on the far side so remoteKill can signal all of it.
+	script := fmt.Sprintf("cd %v && exec setsid bash -c %v",
+		shellQuote(r.RemoteDir),
+		shellQuote("echo $$ > "+pidFile+" && trap "+shellQuote("rm -f "+pidFile)+" EXIT && bash -c "+shellQuote(buildCmd)))
+	// Later entries win, as they do locally.
+	for i := len(env) - 1; i >= 0; i-- {
+		kv := strings.SplitN(env[i], "=", 2)
+		script = "export " + kv[0] + "=" + shellQuote(kv[1]) + " && " + script
+	}
+	local := fmt.Sprintf("rsync -az --delete %v %v && ssh %v %v",
+		shellQuote(strings.TrimSuffix(c.WatchDir, "/")+"/"),
+		shellQuote(r.target()+":"+strings.TrimSuffix(r.RemoteDir, "/")+"/"),
+		shellQuote(r.target()),
+		shellQuote(script))
+	return exec.Command("bash", "-c", local)
+}
+
+// Send a signal to a remote build's process group.
+// Killing the local ssh would leave it running.
+func remoteKill(r RemoteConfig, name string, sig syscall.Signal) {
+	script := fmt.Sprintf("kill -%d -- -$(cat %v)", int(sig), shellQuote(remotePidFile(name)))
+	err := exec.Command("ssh", r.target(), script).Run()
+	if err != nil {
+		logDebug("could not send %v to remote build %v: %v", sig, name, err)
+	}
+}
+
+// Quote each argument for bash and join them into one command.
+func shellJoin(argv []string) string {
+	var quoted []string
+	for _, arg := range argv {
+		quoted = append(quoted, shellQuote(arg))
+	}
+	return strings.Join(quoted, " ")
+}
+
+// Quote s for bash.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,114 @@
+package engine
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// Rule runs its own command instead of BuildCmd for the changed files it matches.
+type Rule struct {
+	// Matched against changed paths relative to WatchDir.
+	// Paths outside WatchDir, from ExtraWatchPaths, are matched as absolute paths.
+	Match *regexp.Regexp
+	// Run with bash. May use the same template actions as BuildCmd,
+	// with ChangedFiles being the files this rule matched.
+	Cmd string
+}
+
+type rawRule struct {
+	Match *string
+	Cmd   *string
+}
+
+func readRules(rrs []rawRule) ([]Rule, ConfigErrors) {
+	var rules []Rule
+	var errs ConfigErrors
+	for i, rr := range rrs {
+		var rule Rule
+		var err error
+		if rr.Match == nil {
+			errs = append(errs, fmt.Errorf("missing required config value: Rule[%v].Match", i))
+		} else if rule.Match, err = regexp.Compile(*rr.Match); err != nil {
+			errs = append(errs, fmt.Errorf("invalid Rule[%v].Match: %v", i, err))
+		}
+		if rr.Cmd == nil || *rr.Cmd == "" {
+			errs = append(errs, fmt.Errorf("missing required config value: Rule[%v].Cmd", i))
+		} else {
+			rule.Cmd = *rr.Cmd
+			if strings.Contains(rule.Cmd, "{{") {
+				if _, err := template.New("Cmd").Parse(rule.Cmd); err != nil {
+					errs = append(errs, fmt.Errorf("invalid Rule[%v].Cmd: %v", i, err))
+				}
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, errs
+}
+
+// Route each changed file to the first Rule matching it.
+// Returns the files each rule matched, indexed like c.Rules,
+// and the files no rule matched, which are for BuildCmd.
+func routeChanged(c Config, changedRel []string) ([][]string, []string) {
+	matched := make([][]string, len(c.Rules))
+	var rest []string
+	for _, f := range changedRel {
+		routed := false
+		for i, rule := range c.Rules {
+			if rule.Match.MatchString(f) {
+				matched[i] = append(matched[i], f)
+				routed = true
+				break
+			}
+		}
+		if !routed {
+			rest = append(rest, f)
+		}
+	}
+	return matched, rest
+}
+
+// Render the bash command for a build with Rules: the commands of the rules
+// that matched any changed file in the order of c.Rules, then BuildCmd
+// if any file matched no rule, each run only if the ones before it succeeded.
+// Builds with no changed files, like manual or on start, only run BuildCmd.
+// Returns ok false when the build should run BuildCmd or BuildArgv as usual.
+func renderRuleCmds(c Config, changedRel []string) (cmd string, ok bool, err error) {
+	if len(c.Rules) == 0 || len(changedRel) == 0 {
+		return "", false, nil
+	}
+	matched, rest := routeChanged(c, changedRel)
+	if len(rest) == len(changedRel) {
+		return "", false, nil
+	}
+	var cmds []string
+	for i, rule := range c.Rules {
+		if len(matched[i]) == 0 {
+			continue
+		}
+		r, err := renderBuildTemplate(c, fmt.Sprintf("Rule[%v].Cmd", i), rule.Cmd, matched[i])
+		if err != nil {
+			return "", false, err
+		}
+		cmds = append(cmds, "("+r+")")
+	}
+	if len(rest) > 0 {
+		var r string
+		if len(c.BuildArgv) > 0 {
+			argv, err := renderBuildArgv(c, rest)
+			if err != nil {
+				return "", false, err
+			}
+			r = shellJoin(argv)
+		} else {
+			r, err = renderBuildCmd(c, rest)
+			if err != nil {
+				return "", false, err
+			}
+		}
+		cmds = append(cmds, "("+r+")")
+	}
+	return strings.Join(cmds, " && "), true, nil
+}
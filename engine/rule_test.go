@@ -0,0 +1,58 @@
+package engine
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestRenderRuleCmds(t *testing.T) {
+	c := Config{
+		WatchDir: "/src",
+		BuildCmd: "go build ./...",
+		Rules: []Rule{
+			{Match: regexp.MustCompile(`_test\.go$`), Cmd: "go test {{range .ChangedFiles}}./{{.}} {{end}}"},
+			{Match: regexp.MustCompile(`\.proto$`), Cmd: "make proto"},
+			{Match: regexp.MustCompile(`\.go$`), Cmd: "go vet"},
+		},
+	}
+	cases := []struct {
+		changed []string
+		cmd     string
+		ok      bool
+	}{
+		{nil, "", false},
+		{[]string{"README.md"}, "", false},
+		{[]string{"a.proto"}, "(make proto)", true},
+		// Rules run in config order whatever order files changed in, and a file only goes to its first match.
+		{[]string{"b.proto", "x/a_test.go", "main.go"}, "(go test ./x/a_test.go ) && (make proto) && (go vet)", true},
+		{[]string{"README.md", "a.proto"}, "(make proto) && (go build ./...)", true},
+	}
+	for _, tc := range cases {
+		cmd, ok, err := renderRuleCmds(c, tc.changed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cmd != tc.cmd || ok != tc.ok {
+			t.Errorf("%q: got %q %v, expected %q %v", tc.changed, cmd, ok, tc.cmd, tc.ok)
+		}
+	}
+
+	c.BuildCmd = ""
+	c.BuildArgv = []string{"make", "all"}
+	cmd, _, err := renderRuleCmds(c, []string{"a.proto", "Makefile"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd != "(make proto) && ('make' 'all')" {
+		t.Errorf("BuildArgv fallback rendered as %q", cmd)
+	}
+}
+
+func TestReadRules(t *testing.T) {
+	match := "("
+	cmd := "make"
+	_, errs := readRules([]rawRule{{Match: &match, Cmd: &cmd}, {}})
+	if len(errs) != 3 {
+		t.Errorf("expected 3 errors, got %v", errs)
+	}
+}
@@ -0,0 +1,784 @@
+// Package engine is builderator's watch, build and report loop.
+//
+// Run watches a Config's WatchDir and re-runs its BuildCmd on every change,
+// canceling any build in progress, and reports each result to the status file,
+// the status bar and the terminal.
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Run watches and rebuilds according to c until ctx is canceled.
+// Returns nil once ctx is canceled, or an error if watching could not start.
+func Run(ctx context.Context, c Config) error {
+	return NewRunner(c).Run(ctx)
+}
+
+// Runner runs the watch, build and report loop for one config.
+type Runner struct {
+	// Exit Run after the first build finishes.
+	Once bool
+	// Run this instead of the config's BuildCmd or BuildArgv, even after it is reloaded.
+	BuildCmd string
+	// Build on changes from this instead of watching WatchDir, if set.
+	Watcher Watcher
+	// Start build commands with this instead of as local processes, if set.
+	CommandRunner CommandRunner
+	// Also report every state to these, for tasks run together.
+	Aggregators []*Aggregator
+	// Only log the changes that would trigger builds, never building or reporting.
+	WatchOnly bool
+	// Clear the terminal before each build even if the config doesn't say to.
+	ClearScreen bool
+	// Changes to these files never trigger builds, like the log file of a -daemon.
+	IgnorePaths []string
+	// Log what becomes of every changed file, to tell why it did or didn't start a build.
+	Explain bool
+
+	config    Config
+	statusBar *StatusBar
+	watchCh   chan []string
+	metrics   *buildMetrics
+
+	// Guards the fields below which are read by the control server.
+	mu         sync.Mutex
+	state      string
+	lastResult *BuildResult
+	history    []HistoryEntry
+
+	// The color last sent to the status bar.
+	barColor string
+	// When OnFailureSound last played.
+	lastSound time.Time
+	// The state OnStatusChange last ran for.
+	hookState string
+	// STATE_OK or STATE_FAILED for the last build that ran to completion, or "" before one has.
+	lastOutcome string
+	// How many builds in a row have failed.
+	failures int
+	// When the first of those failures finished.
+	failingSince time.Time
+}
+
+var stateStatusBarColors = map[string]string{
+	STATE_IDLE:      StatusBarWhite,
+	STATE_WARMING:   StatusBarYellow,
+	STATE_BUILDING:  StatusBarBlue,
+	STATE_CANCELING: StatusBarOrange,
+	STATE_OK:        StatusBarBlack,
+	STATE_FAILED:    StatusBarRed,
+}
+
+// What the status bar shows for a state, from colors or else the AnyBar default.
+func statusBarColor(colors map[string]string, state string) string {
+	if color, ok := colors[state]; ok {
+		return color
+	}
+	return stateStatusBarColors[state]
+}
+
+func NewRunner(c Config) *Runner {
+	r := &Runner{
+		config:  c,
+		watchCh: make(chan []string),
+		metrics: newBuildMetrics(c.Task),
+	}
+	r.statusBar = newStatusBar(c)
+	return r
+}
+
+// Trigger requests a rebuild as if files had changed.
+// Blocks until the running loop accepts it.
+func (r *Runner) Trigger() {
+	r.watchCh <- nil
+}
+
+// Run watches and rebuilds until ctx is canceled.
+// A build in progress is canceled before returning.
+// This method leaks goroutines.
+func (r *Runner) Run(ctx context.Context) error {
+	r.config = r.withBuildCmd(r.config)
+	c := r.config
+	watchCh := r.watchCh
+	explain := r.explainer(c)
+	// Don't leave the last state held back by StatusWriteInterval unwritten.
+	defer FlushStatusWrites()
+
+	// The watcher's changes pass through the debouncer on their way to watchCh.
+	// Manual triggers go straight to watchCh.
+	rawWatchCh := make(chan []string)
+	debounce := newDebouncer(c.DebounceMode, c.DebounceWindow)
+	debounce.explain = explain
+	debounceCtx, stopDebounce := context.WithCancel(ctx)
+	defer stopDebounce()
+	go debounce.run(debounceCtx, rawWatchCh, watchCh)
+
+	watcher := r.Watcher
+	if watcher == nil {
+		watcher = NewWatcher(c)
+	}
+	stopWatch, err := forwardWatcher(ctx, watcher, rawWatchCh, r.watchFilter(c))
+	if err != nil {
+		return fmt.Errorf("could not start watcher: %v", err)
+	}
+	defer func() { stopWatch() }()
+
+	if r.WatchOnly {
+		r.logChanges(ctx, c, watchCh)
+		return nil
+	}
+
+	// Embedders may build a Config by hand with no file to reload.
+	configCh := make(chan []string)
+	stopConfigWatch := func() {}
+	if c.ConfigPath != "" {
+		stopConfigWatch, err = watchConfigFiles(configCh, c)
+		if err != nil {
+			return fmt.Errorf("could not start config watcher: %v", err)
+		}
+	}
+	defer func() { stopConfigWatch() }()
+
+	if c.ControlPort > 0 {
+		// Canceled before shutdown so no handler is left waiting on the loop.
+		serveCtx, stopServing := context.WithCancel(ctx)
+		defer stopServing()
+		srv, err := r.serveControl(serveCtx, c, watchCh)
+		if err != nil {
+			return fmt.Errorf("could not start control server: %v", err)
+		}
+		defer func() {
+			stopServing()
+			shutdownServer(srv)
+		}()
+	}
+
+	if c.MetricsPort > 0 {
+		srv, err := r.serveMetrics(c)
+		if err != nil {
+			return fmt.Errorf("could not start metrics server: %v", err)
+		}
+		defer shutdownServer(srv)
+	}
+
+	stopHeartbeat := startHeartbeat(c)
+	defer func() { stopHeartbeat() }()
+
+	// Files changed since the last build that ran to completion.
+	var changed []string
+	// Changes before this are ignored, set by PostBuildCooldown.
+	var mutedUntil time.Time
+	// Builds abandoned by FastRestart that haven't exited yet.
+	var aborting sync.WaitGroup
+	buildCtx, cancelBuild := context.WithCancel(ctx)
+	// Stays nil until the first change when not building on start.
+	var buildResultCh <-chan BuildResult
+	// Limits builds to MaxBuildsPerMinute and MinBuildInterval.
+	throttle := newBuildThrottle(c.MaxBuildsPerMinute, c.MinBuildInterval, time.Now())
+	// Fires when the throttle allows the build it held back. nil when none is held.
+	var throttleCh <-chan time.Time
+	// Fires when builds have been failing for NotifyAfter. nil unless waiting for that.
+	var notifyCh <-chan time.Time
+	// Fires when it's time to (re)try reading the changed config. nil unless reloading.
+	var reloadCh <-chan time.Time
+	// Failed reads so far of the changed config.
+	reloadFailures := 0
+	// No build has run to completion yet, so the next one is the baseline.
+	baseline := true
+	// With FinishThenRebuild, changes during a build wait here for it to finish.
+	var pending []string
+	dirty := false
+	// Gets a value when the build's PreBuildCmd is done. nil unless warming.
+	var warmedCh chan struct{}
+	// With StatusFlushInterval, the output of the build in progress and when to write it to StatusFile.
+	var progress *progressBuffer
+	var flushTicker *time.Ticker
+	var flushCh <-chan time.Time
+	// What the last flush wrote, to skip writing it again.
+	var flushed string
+	stopFlush := func() {
+		if flushTicker != nil {
+			flushTicker.Stop()
+		}
+		flushTicker, flushCh, progress, flushed = nil, nil, nil, ""
+	}
+	defer stopFlush()
+	// Start a build with the changes so far, warming up first if there is a PreBuildCmd.
+	start := func() {
+		clearScreen(c, r.ClearScreen)
+		if c.PreBuildCmd == "" {
+			warmedCh = nil
+			r.setState(c, STATE_BUILDING, nil)
+		} else {
+			// A fresh channel so a build that was abandoned can't end the next one's warming.
+			warmedCh = make(chan struct{}, 1)
+			r.setState(c, STATE_WARMING, nil)
+		}
+		stopFlush()
+		// Left a nil io.Writer when off, since build would write to a nil *progressBuffer.
+		var out io.Writer
+		if c.StatusFlushInterval > 0 && c.StreamOutput {
+			progress = newProgressBuffer(c.MaxOutputBytes)
+			out = progress
+			flushTicker = time.NewTicker(c.StatusFlushInterval)
+			flushCh = flushTicker.C
+		}
+		buildResultCh = buildWithRetries(buildCtx, c, r.CommandRunner, changed, warmedCh, out)
+	}
+	restored := r.restoreStatus(c)
+	active := c.BuildOnStart
+	if active {
+		throttle.take(time.Now())
+		start()
+	} else if !restored {
+		r.setState(c, STATE_IDLE, nil)
+	}
+	if !restored {
+		r.setStatusBar(c.InitBuildColor)
+	}
+
+	// Abort the build in progress, if any, and start another with the changes so far.
+	// Returns false when Run should return instead, after a build for Once.
+	rebuild := func() bool {
+		if active && c.FastRestart && !r.Once {
+			cancelBuild()
+			// Let the old build die in the background.
+			// Its result is buffered so nothing blocks on it going unread.
+			aborting.Add(1)
+			go func(resultCh <-chan BuildResult) {
+				defer aborting.Done()
+				res := <-resultCh
+				logDebug("%vprevious build finished aborting: %v", taskPrefix(c), res.Error)
+			}(buildResultCh)
+		} else if active {
+			cancelBuild()
+
+			r.setState(c, STATE_CANCELING, nil)
+
+			// Wait for the abort to effect.
+			res := <-buildResultCh
+			err := r.report(c, res)
+			if err != nil {
+				logWarn("%v", err)
+			}
+			if r.Once {
+				return false
+			}
+		}
+
+		buildCtx, cancelBuild = context.WithCancel(ctx)
+		start()
+		active = true
+		return true
+	}
+
+	for {
+		select {
+		case files := <-watchCh:
+			// Manual triggers have no files and are never muted.
+			if len(files) > 0 && time.Now().Before(mutedUntil) {
+				logDebug("%vignoring %v changed files during PostBuildCooldown", taskPrefix(c), len(files))
+				explain.explain(files, fmt.Sprintf("ignored, within PostBuildCooldown (%v) of the last build", c.PostBuildCooldown))
+				continue
+			}
+			r.logChanged(c, files)
+			if active && c.FinishThenRebuild {
+				pending = mergeChanged(pending, files)
+				dirty = true
+				explain.explain(files, "saved for after the build in progress (FinishThenRebuild)")
+				continue
+			}
+			changed = mergeChanged(changed, files)
+			if throttleCh != nil {
+				// Saved up for the build the throttle is holding back.
+				explain.explain(files, fmt.Sprintf("saved for the build %v is holding back", throttle.heldBy))
+				continue
+			}
+			if wait := throttle.take(time.Now()); wait > 0 {
+				if throttle.heldBy == "MinBuildInterval" {
+					// Expected with MinBuildInterval, unlike going over MaxBuildsPerMinute.
+					logInfo("%vwithin MinBuildInterval (%v) of the last build, holding changes for %v", taskPrefix(c), c.MinBuildInterval, wait.Round(time.Millisecond))
+				} else {
+					logWarn("%vover MaxBuildsPerMinute (%v), holding changes for %v", taskPrefix(c), c.MaxBuildsPerMinute, wait.Round(time.Millisecond))
+				}
+				explain.explain(files, fmt.Sprintf("held back by %v for %v", throttle.heldBy, wait.Round(time.Millisecond)))
+				throttleCh = time.After(wait)
+				continue
+			}
+			if active {
+				explain.explain(files, "aborting the build in progress and building")
+			} else {
+				explain.explain(files, "building")
+			}
+			if !rebuild() {
+				return nil
+			}
+		case <-throttleCh:
+			throttleCh = nil
+			throttle.take(time.Now())
+			if !rebuild() {
+				return nil
+			}
+		case <-warmedCh:
+			warmedCh = nil
+			r.setState(c, STATE_BUILDING, nil)
+		case <-flushCh:
+			if output := progress.String(); output != flushed {
+				flushed = output
+				r.flushProgress(c, output)
+			}
+		case res := <-buildResultCh:
+			cancelBuild()
+			warmedCh = nil
+			stopFlush()
+			err := r.report(c, res)
+			if err != nil {
+				logWarn("%v", err)
+			}
+			// Not for builds canceled by a change, which are reported above.
+			r.metrics.observe(res)
+			r.runSuccessHook(c, res)
+			escalated := r.countFailures(c, res)
+			if res.Error == nil {
+				notifyCh = nil
+			} else if baseline && c.SuppressFirstNotification {
+				logDebug("%vfirst build failed, not playing failure sound for the baseline", taskPrefix(c))
+			} else if wait := notifyWait(c.NotifyAfter, r.failingSince, time.Now()); wait > 0 {
+				if notifyCh == nil {
+					logDebug("%vfailing for less than NotifyAfter, not playing failure sound for %v", taskPrefix(c), wait.Round(time.Millisecond))
+					notifyCh = time.After(wait)
+				}
+			} else {
+				r.playFailureSound(c, escalated)
+			}
+			active = false
+			baseline = false
+			if throttleCh == nil {
+				changed = nil
+			}
+			// Otherwise keep them for the build the throttle is holding back,
+			// which may include changes made while this one ran.
+			mutedUntil = time.Now().Add(c.PostBuildCooldown)
+			if r.Once {
+				return nil
+			}
+			if dirty {
+				logDebug("%vbuilding the %v files changed during the last build", taskPrefix(c), len(pending))
+				changed, pending, dirty = pending, nil, false
+				if wait := throttle.take(time.Now()); wait > 0 {
+					throttleCh = time.After(wait)
+				} else if !rebuild() {
+					return nil
+				}
+			}
+		case <-notifyCh:
+			notifyCh = nil
+			if r.failures > 0 {
+				r.playFailureSound(c, false)
+			}
+		case <-configCh:
+			// Editors may leave the file empty or half-written for a moment while saving.
+			reloadFailures = 0
+			reloadCh = time.After(CONFIG_RELOAD_DELAY)
+		case <-reloadCh:
+			reloadCh = nil
+			nc, err := ReadTask(c.ConfigPath, c.Task)
+			if err != nil {
+				reloadFailures++
+				if reloadFailures < CONFIG_RELOAD_ATTEMPTS {
+					reloadCh = time.After(CONFIG_RELOAD_DELAY)
+				} else {
+					logWarn("%vkeeping previous config, could not reload: %v", taskPrefix(c), err)
+				}
+				continue
+			}
+			for _, err := range nc.Warnings {
+				logWarn("%v%v", taskPrefix(c), err)
+			}
+			nc = r.withBuildCmd(nc)
+			watchChanged := nc.WatchDir != c.WatchDir || nc.WatchBackend != c.WatchBackend || nc.PollInterval != c.PollInterval ||
+				strings.Join(nc.ExtraWatchPaths, "\x00") != strings.Join(c.ExtraWatchPaths, "\x00")
+			ignoreChanged := !nc.Ignore.Equal(c.Ignore) ||
+				strings.Join(nc.WatchExcludes, "\x00") != strings.Join(c.WatchExcludes, "\x00")
+			if (r.Watcher == nil && watchChanged) || ignoreChanged {
+				watcher := r.Watcher
+				if watcher == nil {
+					watcher = NewWatcher(nc)
+				}
+				newStopWatch, err := forwardWatcher(ctx, watcher, rawWatchCh, r.watchFilter(nc))
+				if err != nil {
+					logWarn("%vkeeping previous config, could not watch %v: %v", taskPrefix(c), nc.WatchDir, err)
+					continue
+				}
+				stopWatch()
+				stopWatch = newStopWatch
+			}
+			// The ignore file is only watched while it exists.
+			if nc.WatchBackend != c.WatchBackend || nc.PollInterval != c.PollInterval || (nc.Ignore == nil) != (c.Ignore == nil) {
+				newStopConfigWatch, err := watchConfigFiles(configCh, nc)
+				if err == nil {
+					stopConfigWatch()
+					stopConfigWatch = newStopConfigWatch
+				} else {
+					logWarn("%vcould not re-watch config file: %v", taskPrefix(c), err)
+				}
+			}
+			if !sameStringPtr(nc.HeartbeatFile, c.HeartbeatFile) || nc.HeartbeatInterval != c.HeartbeatInterval {
+				stopHeartbeat()
+				stopHeartbeat = startHeartbeat(nc)
+			}
+			debounce.configure(nc.DebounceMode, nc.DebounceWindow)
+			if nc.MaxBuildsPerMinute != c.MaxBuildsPerMinute || nc.MinBuildInterval != c.MinBuildInterval {
+				throttle = newBuildThrottle(nc.MaxBuildsPerMinute, nc.MinBuildInterval, time.Now())
+			}
+			if nc.StatusBarPort != c.StatusBarPort || nc.StatusBarProto != c.StatusBarProto || nc.StatusBarKeepAlive != c.StatusBarKeepAlive {
+				if r.statusBar != nil {
+					r.statusBar.Close()
+				}
+				r.statusBar = newStatusBar(nc)
+				// The new status bar has not been told anything yet.
+				r.barColor = ""
+			}
+			c = nc
+			r.config = c
+			logInfo("%vconfig reloaded", taskPrefix(c))
+			PrintConfig(c)
+		case <-ctx.Done():
+			// The build's context is canceled along with ctx.
+			if active {
+				<-buildResultCh
+			}
+			aborting.Wait()
+			cancelBuild()
+			return nil
+		}
+	}
+}
+
+// Which changes from the watcher can trigger builds.
+func (r *Runner) watchFilter(c Config) watchFilter {
+	skip := append(append([]string(nil), r.IgnorePaths...), c.WatchExcludes...)
+	return watchFilter{c.Ignore, skip, r.explainer(c)}
+}
+
+// Whether two optional strings are both unset or both the same.
+func sameStringPtr(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// Log every batch of changes with all of its files until ctx is canceled.
+// Used instead of building in WatchOnly mode.
+func (r *Runner) logChanges(ctx context.Context, c Config, watchCh <-chan []string) {
+	prefix := taskPrefix(c)
+	logInfo("%vwatching only, builds are disabled", prefix)
+	for {
+		select {
+		case files := <-watchCh:
+			if len(files) == 0 {
+				logInfo("%vbuild triggered, ignoring", prefix)
+				continue
+			}
+			rel := relChanged(c, files)
+			fields := logFields{"event": "changed", "changedFiles": rel}
+			if c.Task != "" {
+				fields["task"] = c.Task
+			}
+			logInfoFields(fields, "%vfiles changed: %v", prefix, strings.Join(rel, ", "))
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// The status bar for a config, or nil if it has none.
+func newStatusBar(c Config) *StatusBar {
+	if c.StatusBarPort == 0 {
+		return nil
+	}
+	s := NewStatusBar(c.StatusBarPort)
+	s.Proto = c.StatusBarProto
+	s.KeepAlive = c.StatusBarKeepAlive
+	s.Timeout = c.StatusBarTimeout
+	return s
+}
+
+// Send a color to the status bar, coalescing bursts of changes.
+func (r *Runner) setStatusBar(style string) {
+	r.barColor = style
+	if r.statusBar != nil && !r.sharesStatusBar() {
+		r.statusBar.SetLatest(style)
+	}
+}
+
+// Send a title to the status bar in the background.
+func (r *Runner) setStatusBarTitle(text string) {
+	statusBar := r.statusBar
+	if statusBar == nil || r.sharesStatusBar() {
+		return
+	}
+	go statusBar.trySend(fmt.Sprintf("title %q", text), func() error {
+		return statusBar.SetTitle(context.Background(), text)
+	})
+}
+
+// Count consecutive failed builds, resetting on success.
+// Past EscalateAfterFailures the status bar shows StatusBarExclamation instead of red.
+// Returns whether the failures have escalated.
+func (r *Runner) countFailures(c Config, res BuildResult) bool {
+	if res.Error == nil {
+		r.failures = 0
+		return false
+	}
+	r.failures++
+	if r.failures == 1 {
+		r.failingSince = time.Now()
+	}
+	if c.EscalateAfterFailures == 0 || r.failures <= c.EscalateAfterFailures {
+		return false
+	}
+	if r.failures == c.EscalateAfterFailures+1 {
+		logWarn("%v%v builds failed in a row", taskPrefix(c), r.failures)
+	}
+	r.setStatusBar(StatusBarExclamation)
+	return true
+}
+
+// Whether an Aggregator sets the status bar for this task and others.
+func (r *Runner) sharesStatusBar() bool {
+	for _, a := range r.Aggregators {
+		if a.StatusBar != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Record the current state and publish it to the status file and status bar.
+// `res` is the finished build for STATE_OK and STATE_FAILED, otherwise nil.
+func (r *Runner) setState(c Config, state string, res *BuildResult) {
+	r.mu.Lock()
+	r.state = state
+	if res != nil {
+		r.lastResult = res
+	}
+	r.mu.Unlock()
+	r.metrics.setState(state)
+	for _, a := range r.Aggregators {
+		a.Update(c.Task, state)
+	}
+	r.runStatusHook(c, state)
+	writeState(c, state, res)
+	// Without a StatusFile the status bar only shows outcomes.
+	if c.StatusFile == nil && res == nil {
+		return
+	}
+	// Unless AlwaysReport is set, the status bar isn't sent the color it already shows.
+	if color := statusBarColor(c.StatusBarColors, state); c.AlwaysReport || color != r.barColor {
+		r.setStatusBar(color)
+	}
+}
+
+// Write the output so far of the build in progress to StatusFile, for StatusFlushInterval.
+// Runs on the Run loop like the final report, so the two never race.
+func (r *Runner) flushProgress(c Config, output string) {
+	r.mu.Lock()
+	state := r.state
+	r.mu.Unlock()
+	res := &BuildResult{Stdout: output}
+	if c.TooltipLines > 0 {
+		res.Tail = tailLines(output, c.TooltipLines)
+	}
+	writeState(c, state, res)
+}
+
+// Pick up the outcome the last run left in a json StatusFile,
+// so the status bar shows it until the first build finishes
+// and that build is only published if its outcome differs.
+// Returns whether there was an outcome to restore.
+func (r *Runner) restoreStatus(c Config) bool {
+	if c.StatusFile == nil || c.StatusFormat != STATUS_FORMAT_JSON {
+		return false
+	}
+	s, err := readStatusJSON(*c.StatusFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logDebug("not restoring status from %v: %v", *c.StatusFile, err)
+		}
+		return false
+	}
+	if s.State != STATE_OK && s.State != STATE_FAILED {
+		return false
+	}
+	res := &BuildResult{Stdout: s.Stdout, Stderr: s.Stderr}
+	if s.Error != "" {
+		res.Error = errors.New(s.Error)
+	}
+	r.mu.Lock()
+	r.state = s.State
+	r.lastResult = res
+	r.mu.Unlock()
+	r.lastOutcome = s.State
+	r.setStatusBar(statusBarColor(c.StatusBarColors, s.State))
+	logDebug("restored last status %v from %v", s.State, *c.StatusFile)
+	return true
+}
+
+// The current state along with the last finished build.
+func (r *Runner) currentStatus() StatusJSON {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return NewStatusJSON(r.state, r.lastResult)
+}
+
+// Config is the config Run builds with, which has BuildCmd in place of the config's if set.
+// Call it before Run, which keeps its own after a reload.
+func (r *Runner) Config() Config {
+	return r.withBuildCmd(r.config)
+}
+
+// The config with BuildCmd in place of its own, if set.
+func (r *Runner) withBuildCmd(c Config) Config {
+	if r.BuildCmd != "" {
+		c.BuildCmd = r.BuildCmd
+		c.BuildArgv = nil
+	}
+	return c
+}
+
+// History is the last HistorySize finished builds, oldest first.
+func (r *Runner) History() []HistoryEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]HistoryEntry(nil), r.history...)
+}
+
+// LastResult is the last finished build, or nil if none has finished.
+func (r *Runner) LastResult() *BuildResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lastResult
+}
+
+// Watch the config file, and the ignore file next to it if there is one.
+// Returns a func that stops watching both.
+func watchConfigFiles(ch chan<- []string, c Config) (func(), error) {
+	stopConfig, err := watch(ch, c, c.ConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	if c.Ignore == nil {
+		return stopConfig, nil
+	}
+	stopIgnore, err := watch(ch, c, c.Ignore.Path)
+	if err != nil {
+		stopConfig()
+		return nil, err
+	}
+	return func() {
+		stopConfig()
+		stopIgnore()
+	}, nil
+}
+
+// A changed config is read CONFIG_RELOAD_DELAY after the change,
+// trying CONFIG_RELOAD_ATTEMPTS times in all, CONFIG_RELOAD_DELAY apart.
+const (
+	CONFIG_RELOAD_DELAY    = 100 * time.Millisecond
+	CONFIG_RELOAD_ATTEMPTS = 3
+)
+
+func (r *Runner) report(c Config, res BuildResult) error {
+	r.mu.Lock()
+	r.history = appendHistory(r.history, NewHistoryEntry(res, time.Now()), c.HistorySize)
+	r.mu.Unlock()
+	if c.TooltipLines > 0 {
+		res.Tail = tailLines(res.Output(), c.TooltipLines)
+	}
+	if res.Error == nil {
+		r.setState(c, STATE_OK, &res)
+	} else {
+		r.setState(c, STATE_FAILED, &res)
+	}
+	// A success after the last build succeeded is nothing new to tell, unless AlwaysReport is set.
+	// lastOutcome is still the previous build's here.
+	repeated := res.Error == nil && r.lastOutcome == STATE_OK
+	if c.StatusBarTitles && (c.AlwaysReport || !repeated) {
+		r.setStatusBarTitle(statusBarTitle(res))
+	}
+	prefix := taskPrefix(c)
+	fields := logFields{
+		"event":      "build",
+		"success":    res.Error == nil,
+		"durationMs": res.Duration.Nanoseconds() / int64(time.Millisecond),
+	}
+	if c.Task != "" {
+		fields["task"] = c.Task
+	}
+	switch {
+	case res.Error == nil:
+		logInfoFields(fields, "%v✓", prefix)
+	case c.StreamOutput:
+		// The output was already streamed.
+		fields["error"] = res.Error.Error()
+		logErrorFields(fields, "%v✗ build failed: %v", prefix, res.Error)
+	default:
+		fields["error"] = res.Error.Error()
+		fields["stdout"] = res.Stdout
+		fields["stderr"] = res.Stderr
+		// Diagnostics usually land on stderr, so show them first.
+		logErrorFields(fields, "%v✗ build failed: %v %v%v", prefix, res.Error, res.Stderr, res.Stdout)
+	}
+	return nil
+}
+
+// Marks which task a log line is about, when there are tasks.
+func taskPrefix(c Config) string {
+	if c.Task == "" {
+		return ""
+	}
+	return "[" + c.Task + "] "
+}
+
+// How many changed files to list before summarizing the rest.
+const MAX_LOGGED_CHANGED = 5
+
+// Log the files in a batch of changes.
+// Manual triggers have none and are logged by whoever triggered them.
+func (r *Runner) logChanged(c Config, files []string) {
+	prefix := taskPrefix(c)
+	fields := logFields{"event": "changed", "changedFiles": relChanged(c, files)}
+	if c.Task != "" {
+		fields["task"] = c.Task
+	}
+	switch {
+	case len(files) == 0:
+	case logLevel < LOG_DEBUG && len(files) == 1:
+		logInfoFields(fields, "%v1 file changed", prefix)
+	case logLevel < LOG_DEBUG:
+		logInfoFields(fields, "%v%v files changed", prefix, len(files))
+	case len(files) > MAX_LOGGED_CHANGED:
+		rel := relChanged(c, files[:MAX_LOGGED_CHANGED])
+		logDebugFields(fields, "%vfiles changed: %v and %v more", prefix, strings.Join(rel, ", "), len(files)-MAX_LOGGED_CHANGED)
+	default:
+		logDebugFields(fields, "%vfiles changed: %v", prefix, strings.Join(relChanged(c, files), ", "))
+	}
+}
+
+// A short summary of a build for the status bar title.
+func statusBarTitle(res BuildResult) string {
+	if res.Error == nil {
+		return "ok"
+	}
+	return fmt.Sprintf("FAILED: %v", res.Error)
+}
@@ -0,0 +1,436 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// chanWatcher is a Watcher fed by the test.
+type chanWatcher chan Event
+
+func (w chanWatcher) Start(ctx context.Context) (<-chan Event, error) {
+	return w, nil
+}
+
+func nextCommand(t *testing.T, f *FakeCommandRunner) *FakeCommand {
+	select {
+	case fc := <-f.Started():
+		return fc
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a build to start")
+		return nil
+	}
+}
+
+func waitHistory(t *testing.T, r *Runner, n int) []HistoryEntry {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if h := r.History(); len(h) >= n {
+			return h
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %v builds to finish, have %v", n, r.History())
+	return nil
+}
+
+func TestRunAbortsAndRestarts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "builderator-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := Config{
+		WatchDir:        dir,
+		BuildCmd:        "make",
+		BuildCmdDir:     dir,
+		BuildOnStart:    true,
+		MaxOutputBytes:  DEFAULT_MAX_OUTPUT_BYTES,
+		HistorySize:     DEFAULT_HISTORY_SIZE,
+		KillSignal:      syscall.SIGTERM,
+		KillGracePeriod: 50 * time.Millisecond,
+	}
+	watcher := make(chanWatcher)
+	fake := NewFakeCommandRunner(10)
+	r := NewRunner(c)
+	r.Watcher = watcher
+	r.CommandRunner = fake
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- r.Run(ctx) }()
+
+	first := nextCommand(t, fake)
+	if !reflect.DeepEqual(first.Args, []string{"bash", "-c", "make"}) {
+		t.Errorf("started %q", first.Args)
+	}
+
+	// A change aborts the build in progress and starts another.
+	watcher <- Event{Paths: []string{filepath.Join(dir, "a.go")}}
+	second := nextCommand(t, fake)
+	if sigs := first.Signals(); !reflect.DeepEqual(sigs, []syscall.Signal{syscall.SIGTERM}) {
+		t.Errorf("first build got signals %v", sigs)
+	}
+	second.Exit("built\n", "", nil)
+	h := waitHistory(t, r, 2)
+	if h[0].State != STATE_FAILED || h[1].State != STATE_OK {
+		t.Errorf("unexpected history %+v", h)
+	}
+	if res := r.LastResult(); res == nil || res.Stdout != "built\n" {
+		t.Errorf("unexpected last result %+v", res)
+	}
+
+	// A build that ignores KillSignal is killed after KillGracePeriod.
+	watcher <- Event{Paths: []string{filepath.Join(dir, "b.go")}}
+	third := nextCommand(t, fake)
+	third.IgnoreSignals()
+	watcher <- Event{Paths: []string{filepath.Join(dir, "c.go")}}
+	nextCommand(t, fake)
+	if sigs := third.Signals(); !reflect.DeepEqual(sigs, []syscall.Signal{syscall.SIGTERM, syscall.SIGKILL}) {
+		t.Errorf("stubborn build got signals %v", sigs)
+	}
+
+	// Stopping aborts the last build.
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return")
+	}
+}
+
+func TestRunFinishThenRebuild(t *testing.T) {
+	dir, err := ioutil.TempDir("", "builderator-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := Config{
+		WatchDir:          dir,
+		BuildCmd:          "make {{range .ChangedFiles}}{{.}} {{end}}",
+		BuildCmdDir:       dir,
+		BuildOnStart:      true,
+		MaxOutputBytes:    DEFAULT_MAX_OUTPUT_BYTES,
+		HistorySize:       DEFAULT_HISTORY_SIZE,
+		KillSignal:        syscall.SIGTERM,
+		KillGracePeriod:   50 * time.Millisecond,
+		FinishThenRebuild: true,
+	}
+	watcher := make(chanWatcher)
+	fake := NewFakeCommandRunner(10)
+	r := NewRunner(c)
+	r.Watcher = watcher
+	r.CommandRunner = fake
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Run(ctx)
+
+	// Changes during the build don't abort it.
+	first := nextCommand(t, fake)
+	watcher <- Event{Paths: []string{filepath.Join(dir, "a.go")}}
+	watcher <- Event{Paths: []string{filepath.Join(dir, "b.go")}}
+	select {
+	case fc := <-fake.Started():
+		t.Fatalf("started %q during the build", fc.Args)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if sigs := first.Signals(); len(sigs) != 0 {
+		t.Errorf("build got signals %v", sigs)
+	}
+
+	// Once it finishes, one build runs with everything that changed.
+	first.Exit("", "", nil)
+	second := nextCommand(t, fake)
+	if !reflect.DeepEqual(second.Args, []string{"bash", "-c", "make a.go b.go "}) {
+		t.Errorf("rebuilt with %q", second.Args)
+	}
+	second.Exit("", "", nil)
+	waitHistory(t, r, 2)
+	select {
+	case fc := <-fake.Started():
+		t.Fatalf("started %q with nothing changed", fc.Args)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func waitState(t *testing.T, r *Runner, state string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if r.currentStatus().State == state {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for state %v, have %v", state, r.currentStatus().State)
+}
+
+func TestRunPreBuildCmd(t *testing.T) {
+	dir, err := ioutil.TempDir("", "builderator-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := Config{
+		WatchDir:        dir,
+		BuildCmd:        "make",
+		BuildCmdDir:     dir,
+		PreBuildCmd:     "fetch",
+		BuildOnStart:    true,
+		MaxOutputBytes:  DEFAULT_MAX_OUTPUT_BYTES,
+		HistorySize:     DEFAULT_HISTORY_SIZE,
+		KillSignal:      syscall.SIGTERM,
+		KillGracePeriod: 50 * time.Millisecond,
+	}
+	watcher := make(chanWatcher)
+	fake := NewFakeCommandRunner(10)
+	r := NewRunner(c)
+	r.Watcher = watcher
+	r.CommandRunner = fake
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Run(ctx)
+
+	// Warming while PreBuildCmd runs, then building.
+	pre := nextCommand(t, fake)
+	if !reflect.DeepEqual(pre.Args, []string{"bash", "-c", "fetch"}) {
+		t.Errorf("started %q first", pre.Args)
+	}
+	waitState(t, r, STATE_WARMING)
+	pre.Exit("", "", nil)
+	proper := nextCommand(t, fake)
+	if !reflect.DeepEqual(proper.Args, []string{"bash", "-c", "make"}) {
+		t.Errorf("started %q after PreBuildCmd", proper.Args)
+	}
+	waitState(t, r, STATE_BUILDING)
+	proper.Exit("", "", nil)
+	waitState(t, r, STATE_OK)
+
+	// When PreBuildCmd fails the build fails without running BuildCmd.
+	watcher <- Event{Paths: []string{filepath.Join(dir, "a.go")}}
+	pre = nextCommand(t, fake)
+	pre.Exit("", "no network\n", fmt.Errorf("exit status 1"))
+	h := waitHistory(t, r, 2)
+	if h[1].State != STATE_FAILED {
+		t.Errorf("unexpected history %+v", h)
+	}
+	if res := r.LastResult(); res == nil || res.Stderr != "no network\n" {
+		t.Errorf("unexpected last result %+v", res)
+	}
+	select {
+	case fc := <-fake.Started():
+		t.Fatalf("started %q after PreBuildCmd failed", fc.Args)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func waitStatusFile(t *testing.T, statusFile string, expected string) {
+	deadline := time.Now().Add(5 * time.Second)
+	var got []byte
+	for time.Now().Before(deadline) {
+		got, _ = ioutil.ReadFile(statusFile)
+		if string(got) == expected {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("StatusFile has %q, expected %q", got, expected)
+}
+
+func TestRunStatusFlushInterval(t *testing.T) {
+	dir, err := ioutil.TempDir("", "builderator-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	statusFile := filepath.Join(dir, "status")
+
+	c := Config{
+		WatchDir:            dir,
+		BuildCmd:            "make",
+		BuildCmdDir:         dir,
+		BuildOnStart:        true,
+		StatusFile:          &statusFile,
+		StatusFormat:        STATUS_FORMAT_TEXT,
+		StatusFileMode:      0644,
+		StreamOutput:        true,
+		StatusFlushInterval: 10 * time.Millisecond,
+		MaxOutputBytes:      DEFAULT_MAX_OUTPUT_BYTES,
+		HistorySize:         DEFAULT_HISTORY_SIZE,
+		KillSignal:          syscall.SIGTERM,
+		KillGracePeriod:     50 * time.Millisecond,
+	}
+	watcher := make(chanWatcher)
+	fake := NewFakeCommandRunner(10)
+	r := NewRunner(c)
+	r.Watcher = watcher
+	r.CommandRunner = fake
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Run(ctx)
+
+	// Both builds succeed, and the second outcome still replaces its progress.
+	for _, f := range []string{"", "a.go"} {
+		if f != "" {
+			watcher <- Event{Paths: []string{filepath.Join(dir, f)}}
+		}
+		fc := nextCommand(t, fake)
+		fc.Output("compiling\n", "")
+		waitStatusFile(t, statusFile, "BUILDING\n\ncompiling\n")
+		fc.Exit("done\n", "", nil)
+		waitStatusFile(t, statusFile, "ok\n\ncompiling\ndone\n")
+	}
+}
+
+func TestRunThrottleKeepsChanges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "builderator-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := Config{
+		WatchDir:         dir,
+		BuildCmd:         "make {{range .ChangedFiles}}{{.}} {{end}}",
+		BuildCmdDir:      dir,
+		BuildOnStart:     true,
+		MaxOutputBytes:   DEFAULT_MAX_OUTPUT_BYTES,
+		HistorySize:      DEFAULT_HISTORY_SIZE,
+		KillSignal:       syscall.SIGTERM,
+		KillGracePeriod:  50 * time.Millisecond,
+		MinBuildInterval: 300 * time.Millisecond,
+	}
+	watcher := make(chanWatcher)
+	fake := NewFakeCommandRunner(10)
+	r := NewRunner(c)
+	r.Watcher = watcher
+	r.CommandRunner = fake
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Run(ctx)
+
+	// A change held back by the throttle, and the build in progress finishing before it's let go.
+	first := nextCommand(t, fake)
+	watcher <- Event{Paths: []string{filepath.Join(dir, "a.go")}}
+	time.Sleep(100 * time.Millisecond)
+	first.Exit("", "", nil)
+	waitHistory(t, r, 1)
+
+	second := nextCommand(t, fake)
+	if !reflect.DeepEqual(second.Args, []string{"bash", "-c", "make a.go "}) {
+		t.Errorf("held build ran %q", second.Args)
+	}
+}
+
+func TestRunMinBuildInterval(t *testing.T) {
+	dir, err := ioutil.TempDir("", "builderator-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := Config{
+		WatchDir:         dir,
+		BuildCmd:         "make {{range .ChangedFiles}}{{.}} {{end}}",
+		BuildCmdDir:      dir,
+		BuildOnStart:     true,
+		MaxOutputBytes:   DEFAULT_MAX_OUTPUT_BYTES,
+		HistorySize:      DEFAULT_HISTORY_SIZE,
+		KillSignal:       syscall.SIGTERM,
+		KillGracePeriod:  50 * time.Millisecond,
+		MinBuildInterval: 300 * time.Millisecond,
+	}
+	watcher := make(chanWatcher)
+	fake := NewFakeCommandRunner(10)
+	r := NewRunner(c)
+	r.Watcher = watcher
+	r.CommandRunner = fake
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Run(ctx)
+
+	// Changes while the first build runs, and after it's done, all wait for the interval.
+	first := nextCommand(t, fake)
+	watcher <- Event{Paths: []string{filepath.Join(dir, "a.go")}}
+	time.Sleep(50 * time.Millisecond)
+	if sigs := first.Signals(); len(sigs) != 0 {
+		t.Errorf("held change aborted the build, which got signals %v", sigs)
+	}
+	first.Exit("", "", nil)
+	waitHistory(t, r, 1)
+	watcher <- Event{Paths: []string{filepath.Join(dir, "b.go")}}
+
+	// Then one build runs with all of them.
+	second := nextCommand(t, fake)
+	if !reflect.DeepEqual(second.Args, []string{"bash", "-c", "make a.go b.go "}) {
+		t.Errorf("held build ran %q", second.Args)
+	}
+	second.Exit("", "", nil)
+	waitHistory(t, r, 2)
+	select {
+	case fc := <-fake.Started():
+		t.Fatalf("started %q after the held build", fc.Args)
+	case <-time.After(400 * time.Millisecond):
+	}
+}
+
+func TestRunPublishesEveryState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "builderator-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	statusFile := filepath.Join(dir, "status")
+
+	c := Config{
+		WatchDir:        dir,
+		BuildCmd:        "make",
+		BuildCmdDir:     dir,
+		BuildOnStart:    true,
+		StatusFile:      &statusFile,
+		StatusFormat:    STATUS_FORMAT_TEXT,
+		StatusFileMode:  0644,
+		MaxOutputBytes:  DEFAULT_MAX_OUTPUT_BYTES,
+		HistorySize:     DEFAULT_HISTORY_SIZE,
+		KillSignal:      syscall.SIGTERM,
+		KillGracePeriod: 50 * time.Millisecond,
+	}
+	watcher := make(chanWatcher)
+	fake := NewFakeCommandRunner(10)
+	r := NewRunner(c)
+	r.Watcher = watcher
+	r.CommandRunner = fake
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Run(ctx)
+
+	// Builds in progress show, and a failure after a failure still has its own output.
+	for i, f := range []string{"", "a.go"} {
+		if f != "" {
+			watcher <- Event{Paths: []string{filepath.Join(dir, f)}}
+		}
+		fc := nextCommand(t, fake)
+		waitStatusFile(t, statusFile, "BUILDING")
+		out := fmt.Sprintf("error %v\n", i)
+		fc.Exit("", out, fmt.Errorf("exit status 2"))
+		waitStatusFile(t, statusFile, "FAILED\n\n"+out)
+	}
+}
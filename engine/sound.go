@@ -0,0 +1,64 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// OnFailureSound value that rings the terminal bell instead of playing a file.
+const FAILURE_SOUND_BELL = "bell"
+
+// The least time between failure sounds, so a run of failures doesn't go off every build.
+const FAILURE_SOUND_MIN_INTERVAL = 10 * time.Second
+
+// Programs that can play an audio file, in order of preference.
+var soundPlayers = []string{"afplay", "paplay"}
+
+// Play OnFailureSound for a failed build unless one played recently.
+// Once failures have escalated it plays every time.
+func (r *Runner) playFailureSound(c Config, escalated bool) {
+	if c.OnFailureSound == "" {
+		return
+	}
+	now := time.Now()
+	if !escalated && !r.lastSound.IsZero() && now.Sub(r.lastSound) < FAILURE_SOUND_MIN_INTERVAL {
+		logDebug("failure sound played %v ago, not playing", now.Sub(r.lastSound))
+		return
+	}
+	r.lastSound = now
+	if c.OnFailureSound == FAILURE_SOUND_BELL {
+		fmt.Fprint(os.Stderr, "\a")
+		return
+	}
+	go func() {
+		err := playSound(c.OnFailureSound)
+		if err != nil {
+			logDebug("could not play %v: %v", c.OnFailureSound, err)
+		}
+	}()
+}
+
+// How much longer until builds failing since failingSince have failed for notifyAfter.
+func notifyWait(notifyAfter time.Duration, failingSince time.Time, now time.Time) time.Duration {
+	wait := failingSince.Add(notifyAfter).Sub(now)
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// Play an audio file with the first player found on PATH.
+func playSound(p string) error {
+	for _, name := range soundPlayers {
+		player, err := which(name)
+		if err != nil {
+			return err
+		}
+		if player != nil {
+			return exec.Command(*player, p).Run()
+		}
+	}
+	return fmt.Errorf("no sound player found, looked for %v", soundPlayers)
+}
@@ -0,0 +1,27 @@
+package engine
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNotifyWait(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		notifyAfter time.Duration
+		failingFor  time.Duration
+		expected    time.Duration
+	}{
+		{0, 0, 0},
+		{30 * time.Second, 0, 30 * time.Second},
+		{30 * time.Second, 10 * time.Second, 20 * time.Second},
+		{30 * time.Second, 30 * time.Second, 0},
+		{30 * time.Second, time.Minute, 0},
+	}
+	for _, tc := range cases {
+		actual := notifyWait(tc.notifyAfter, start, start.Add(tc.failingFor))
+		if actual != tc.expected {
+			t.Errorf("NotifyAfter %v failing for %v: expected wait %v, got %v", tc.notifyAfter, tc.failingFor, tc.expected, actual)
+		}
+	}
+}
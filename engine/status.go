@@ -0,0 +1,239 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Build states written to the status file.
+const (
+	STATE_IDLE      = "idle"
+	STATE_WARMING   = "warming" // PreBuildCmd is running.
+	STATE_BUILDING  = "building"
+	STATE_CANCELING = "canceling"
+	STATE_OK        = "ok"
+	STATE_FAILED    = "failed"
+)
+
+// Every build state, in the order a build goes through them.
+var statusStates = []string{STATE_IDLE, STATE_WARMING, STATE_BUILDING, STATE_CANCELING, STATE_OK, STATE_FAILED}
+
+// StatusJSON is the status file contents in StatusFormat json.
+type StatusJSON struct {
+	State  string `json:"state"`
+	Error  string `json:"error,omitempty"`
+	Stdout string `json:"stdout"`
+	Stderr string `json:"stderr"`
+	// The last TooltipLines lines of the output, when TooltipLines is set.
+	Tail string `json:"tail,omitempty"`
+}
+
+func NewStatusJSON(state string, res *BuildResult) StatusJSON {
+	s := StatusJSON{State: state}
+	if res != nil {
+		if res.Error != nil {
+			s.Error = res.Error.Error()
+		}
+		s.Stdout = res.Stdout
+		s.Stderr = res.Stderr
+		s.Tail = res.Tail
+	}
+	return s
+}
+
+// Format the status file contents for a state.
+// `res` is the finished build for STATE_OK and STATE_FAILED,
+// the output so far of one in progress with StatusFlushInterval, otherwise nil.
+func formatStatus(c Config, state string, res *BuildResult) string {
+	if c.StatusFormat == STATUS_FORMAT_JSON {
+		b, err := json.MarshalIndent(NewStatusJSON(state, res), "", "  ")
+		if err != nil {
+			panic(err)
+		}
+		return string(b) + "\n"
+	}
+	switch {
+	case state == STATE_OK && c.StatusOnlyOutputOnFailure:
+		return fmt.Sprintf("ok\n\nfinished %v in %v\n", time.Now().Format("2006-01-02 15:04:05"), res.Duration.Round(time.Millisecond))
+	case state == STATE_OK:
+		return fmt.Sprintf("ok\n\n%v", res.Output())
+	case state == STATE_FAILED:
+		return fmt.Sprintf("FAILED\n\n%v", res.Output())
+	case res != nil:
+		// The output so far of a build in progress.
+		return fmt.Sprintf("%v\n\n%v", strings.ToUpper(state), res.Output())
+	default:
+		return strings.ToUpper(state)
+	}
+}
+
+// Read back a StatusFile written in StatusFormat json.
+func readStatusJSON(p string) (StatusJSON, error) {
+	var s StatusJSON
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return s, err
+	}
+	err = json.Unmarshal(b, &s)
+	return s, err
+}
+
+func writeState(c Config, state string, res *BuildResult) {
+	if c.StatusFile == nil {
+		return
+	}
+	if c.StatusOnlyOutputOnFailure && state == STATE_OK && res != nil {
+		quiet := *res
+		quiet.Stdout = ""
+		quiet.Stderr = ""
+		quiet.Tail = ""
+		res = &quiet
+	}
+	if c.StripANSI && res != nil {
+		stripped := *res
+		stripped.Stdout = stripANSI(res.Stdout)
+		stripped.Stderr = stripANSI(res.Stderr)
+		stripped.Tail = stripANSI(res.Tail)
+		res = &stripped
+	}
+	writeStatus(*c.StatusFile, c.StatusFileMode, c.StatusWriteInterval, formatStatus(c, state, res))
+	// The json status has the tail in it. The tooltip keeps showing
+	// the last build's tail while the next one runs.
+	if c.TooltipLines > 0 && c.StatusFormat != STATUS_FORMAT_JSON && res != nil {
+		writeStatus(TooltipFile(*c.StatusFile), c.StatusFileMode, c.StatusWriteInterval, res.Tail)
+	}
+}
+
+// TooltipFile is where the tail of the output goes next to a text StatusFile.
+func TooltipFile(statusFile string) string {
+	return statusFile + ".tail"
+}
+
+// The last n lines of s, ignoring a trailing newline.
+func tailLines(s string, n int) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Write a status file unless it already has `status`.
+// With an `interval`, writes within it of the last one are held back
+// and the latest of them is written once it is up.
+func writeStatus(path string, mode os.FileMode, interval time.Duration, status string) {
+	statusWritersMu.Lock()
+	w, ok := statusWriters[path]
+	if !ok {
+		w = &statusWriter{path: path}
+		statusWriters[path] = w
+	}
+	statusWritersMu.Unlock()
+	w.write(mode, interval, status)
+}
+
+// FlushStatusWrites writes any status held back by StatusWriteInterval right away.
+func FlushStatusWrites() {
+	statusWritersMu.Lock()
+	defer statusWritersMu.Unlock()
+	for _, w := range statusWriters {
+		w.flush()
+	}
+}
+
+// One statusWriter per path, shared by everything that writes there.
+var statusWritersMu sync.Mutex
+var statusWriters = make(map[string]*statusWriter)
+
+// statusWriter writes one status file, skipping writes that wouldn't change it.
+type statusWriter struct {
+	path string
+
+	mu      sync.Mutex
+	mode    os.FileMode
+	wrote   bool
+	last    string
+	written time.Time
+	// The status held back until timer fires, or nil.
+	pending *string
+	timer   *time.Timer
+}
+
+func (w *statusWriter) write(mode os.FileMode, interval time.Duration, status string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.mode = mode
+	if w.timer != nil {
+		w.pending = &status
+		return
+	}
+	if w.wrote && status == w.last {
+		return
+	}
+	if wait := interval - time.Since(w.written); w.wrote && wait > 0 {
+		w.pending = &status
+		w.timer = time.AfterFunc(wait, w.flush)
+		return
+	}
+	w.writeNow(status)
+}
+
+// Write the held back status, if any.
+func (w *statusWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	if w.pending == nil {
+		return
+	}
+	status := *w.pending
+	w.pending = nil
+	if status != w.last {
+		w.writeNow(status)
+	}
+}
+
+// Must hold mu.
+func (w *statusWriter) writeNow(status string) {
+	err := writeFileAtomic(w.path, []byte(status), w.mode)
+	if err != nil {
+		logWarn("could not write to status file: %v", err)
+		return
+	}
+	w.wrote = true
+	w.last = status
+	w.written = time.Now()
+}
+
+// writeFileAtomic writes to a temp file in the same directory and renames it into place.
+// Concurrent readers see either the old or the new contents, never a partial write.
+func writeFileAtomic(p string, b []byte, mode os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(p), "."+filepath.Base(p)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Chmod(mode)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, p)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
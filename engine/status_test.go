@@ -0,0 +1,246 @@
+package engine
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWriteFileAtomicNoPartialReads(t *testing.T) {
+	dir, err := ioutil.TempDir("", "builderator-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "status")
+
+	contents := []string{
+		"ok\n\n" + strings.Repeat("a", 64*1024),
+		"FAILED\n\n" + strings.Repeat("b", 128*1024),
+	}
+	err = writeFileAtomic(p, []byte(contents[0]), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 500; i++ {
+			err := writeFileAtomic(p, []byte(contents[i%2]), 0644)
+			if err != nil {
+				t.Error(err)
+				break
+			}
+		}
+		close(done)
+	}()
+
+	reads := 0
+	for {
+		select {
+		case <-done:
+			wg.Wait()
+			if reads == 0 {
+				t.Fatal("no reads happened")
+			}
+			return
+		default:
+		}
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := string(b); s != contents[0] && s != contents[1] {
+			t.Fatalf("read partial contents of length %v", len(s))
+		}
+		reads++
+	}
+}
+
+func TestWriteFileAtomicMode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "builderator-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "status")
+
+	err = writeFileAtomic(p, []byte("BUILDING"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Fatalf("mode %v, expected 0644", info.Mode().Perm())
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("expected only the status file, found %v files", len(files))
+	}
+}
+
+func TestReadStatusJSONRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "builderator-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "status")
+	c := Config{StatusFile: &p, StatusFormat: STATUS_FORMAT_JSON, StatusFileMode: 0644}
+
+	res := BuildResult{Error: fmt.Errorf("exit status 2"), Stdout: "out", Stderr: "err"}
+	writeState(c, STATE_FAILED, &res)
+	s, err := readStatusJSON(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := StatusJSON{State: STATE_FAILED, Error: "exit status 2", Stdout: "out", Stderr: "err"}
+	if s != expected {
+		t.Fatalf("read back %+v, expected %+v", s, expected)
+	}
+
+	c.StatusFormat = STATUS_FORMAT_TEXT
+	writeState(c, STATE_OK, &res)
+	if _, err := readStatusJSON(p); err == nil {
+		t.Fatal("expected an error reading a text status file")
+	}
+}
+
+func TestStatusOnlyOutputOnFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "builderator-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "status")
+	c := Config{StatusFile: &p, StatusFormat: STATUS_FORMAT_TEXT, StatusFileMode: 0644, StatusOnlyOutputOnFailure: true}
+
+	read := func() string {
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	writeState(c, STATE_OK, &BuildResult{Stdout: "lots of output"})
+	if s := read(); !strings.HasPrefix(s, "ok\n\nfinished ") || strings.Contains(s, "lots of output") {
+		t.Errorf("success wrote %q", s)
+	}
+	writeState(c, STATE_FAILED, &BuildResult{Error: fmt.Errorf("exit status 1"), Stderr: "the error"})
+	if s := read(); s != "FAILED\n\nthe error" {
+		t.Errorf("failure wrote %q", s)
+	}
+}
+
+func TestTooltipLines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "builderator-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "status")
+	c := Config{StatusFile: &p, StatusFormat: STATUS_FORMAT_TEXT, StatusFileMode: 0644, TooltipLines: 2}
+	r := NewRunner(c)
+
+	r.report(c, BuildResult{Error: fmt.Errorf("exit status 1"), Stdout: "one\ntwo\n", Stderr: "three\nfour\n"})
+	b, err := ioutil.ReadFile(TooltipFile(p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(b); s != "three\nfour" {
+		t.Errorf("text tail %q", s)
+	}
+	if b, _ := ioutil.ReadFile(p); string(b) != "FAILED\n\none\ntwo\nthree\nfour\n" {
+		t.Errorf("status file has %q", b)
+	}
+
+	c.StatusFormat = STATUS_FORMAT_JSON
+	r.report(c, BuildResult{Stdout: "only\n"})
+	s, err := readStatusJSON(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Tail != "only" || s.Stdout != "only\n" {
+		t.Errorf("json status %+v", s)
+	}
+}
+
+func TestTailLines(t *testing.T) {
+	cases := []struct {
+		s        string
+		n        int
+		expected string
+	}{
+		{"", 3, ""},
+		{"a\nb\n", 3, "a\nb"},
+		{"a\nb\nc\nd", 2, "c\nd"},
+		{"a\nb\nc\n\n\n", 1, "c"},
+	}
+	for _, tc := range cases {
+		if got := tailLines(tc.s, tc.n); got != tc.expected {
+			t.Errorf("tailLines(%q, %v) = %q, expected %q", tc.s, tc.n, got, tc.expected)
+		}
+	}
+}
+
+func TestWriteStatusDedupAndInterval(t *testing.T) {
+	dir, err := ioutil.TempDir("", "builderator-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "status")
+	read := func() string {
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	// The same status again is not written.
+	writeStatus(p, 0644, 0, "a")
+	if err := ioutil.WriteFile(p, []byte("changed behind its back"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeStatus(p, 0644, 0, "a")
+	if s := read(); s != "changed behind its back" {
+		t.Errorf("rewrote the same status, have %q", s)
+	}
+
+	// Writes within the interval are held back and the latest lands after it.
+	interval := 200 * time.Millisecond
+	writeStatus(p, 0644, interval, "b")
+	writeStatus(p, 0644, interval, "c")
+	if s := read(); s != "changed behind its back" {
+		t.Errorf("wrote %q within the interval", s)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for read() != "c" && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if s := read(); s != "c" {
+		t.Fatalf("have %q after the interval", s)
+	}
+
+	// Flushing writes what's held back right away.
+	writeStatus(p, 0644, time.Hour, "d")
+	FlushStatusWrites()
+	if s := read(); s != "d" {
+		t.Errorf("have %q after flushing", s)
+	}
+}
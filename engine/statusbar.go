@@ -0,0 +1,315 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	STATUS_BAR_PROTO_UDP = "udp"
+	STATUS_BAR_PROTO_TCP = "tcp"
+)
+
+// How long a send may take when its context has no deadline, unless StatusBar.Timeout says otherwise.
+const DEFAULT_STATUS_BAR_TIMEOUT = time.Second
+
+type StatusBar struct {
+	Port int
+	// STATUS_BAR_PROTO_UDP (the default if empty) or STATUS_BAR_PROTO_TCP.
+	// Over TCP the connection is kept open across sends and each command ends in a newline.
+	Proto string
+	// Keep retrying the latest SetLatest color with backoff until it is delivered,
+	// reconnecting as needed, instead of dropping colors while the status bar is down.
+	KeepAlive bool
+	// How long a send may take when its context has no deadline.
+	// 0 means DEFAULT_STATUS_BAR_TIMEOUT.
+	Timeout time.Duration
+
+	backoff statusBarBackoff
+
+	// The open TCP connection, if any.
+	mu   sync.Mutex
+	conn net.Conn
+
+	// Colors waiting for the SetLatest worker, and its stop signal.
+	colorMu    sync.Mutex
+	colorCh    chan string
+	colorsDone chan struct{}
+}
+
+func NewStatusBar(port int) *StatusBar { return &StatusBar{Port: port} }
+
+const (
+	StatusBarWhite       = "white"
+	StatusBarRed         = "red"
+	StatusBarOrange      = "orange"
+	StatusBarYellow      = "yellow"
+	StatusBarGreen       = "green"
+	StatusBarCyan        = "cyan"
+	StatusBarBlue        = "blue"
+	StatusBarPurple      = "purple"
+	StatusBarBlack       = "black"
+	StatusBarQuestion    = "question"
+	StatusBarExclamation = "exclamation"
+)
+
+// StatusBarColors is every color AnyBar knows.
+var StatusBarColors = []string{
+	StatusBarWhite, StatusBarRed, StatusBarOrange, StatusBarYellow, StatusBarGreen, StatusBarCyan,
+	StatusBarBlue, StatusBarPurple, StatusBarBlack, StatusBarQuestion, StatusBarExclamation,
+}
+
+// Prefix for AnyBar commands that set the title instead of the color.
+const STATUS_BAR_TITLE_PREFIX = "title:"
+
+// Set the status bar color. `style` is one of the StatusBar* colors,
+// or the name of a custom image installed in AnyBar, sent as-is.
+func (s *StatusBar) Set(ctx context.Context, style string) error {
+	return s.send(ctx, style)
+}
+
+// SetTitle sets the text shown with the status bar icon.
+func (s *StatusBar) SetTitle(ctx context.Context, text string) error {
+	return s.send(ctx, STATUS_BAR_TITLE_PREFIX+text)
+}
+
+// How long the SetLatest worker waits after sending a color before sending the next.
+const STATUS_BAR_COLOR_INTERVAL = 50 * time.Millisecond
+
+// SetLatest requests a color change without blocking.
+// A single worker sends at most one color per STATUS_BAR_COLOR_INTERVAL.
+// A color requested while another is still waiting replaces it,
+// so a burst of rebuilds doesn't flicker through every state.
+func (s *StatusBar) SetLatest(style string) {
+	s.colorMu.Lock()
+	if s.colorCh == nil {
+		s.colorCh = make(chan string, 1)
+		s.colorsDone = make(chan struct{})
+		go s.sendColors(s.colorCh, s.colorsDone)
+	}
+	ch := s.colorCh
+	s.colorMu.Unlock()
+	for {
+		select {
+		case ch <- style:
+			return
+		default:
+		}
+		// Drop the color that hasn't been sent yet.
+		select {
+		case <-ch:
+		default:
+		}
+	}
+}
+
+// The SetLatest worker.
+func (s *StatusBar) sendColors(ch <-chan string, done <-chan struct{}) {
+	var style string
+	// Fires when it's time to retry an undelivered color with KeepAlive.
+	var retry <-chan time.Time
+	for {
+		select {
+		case style = <-ch:
+		case <-retry:
+		case <-done:
+			return
+		}
+		retry = nil
+		sent := s.trySend(fmt.Sprintf("color %v", style), func() error {
+			return s.Set(context.Background(), style)
+		})
+		if !sent && s.KeepAlive {
+			retry = time.After(s.backoff.wait(time.Now()))
+			continue
+		}
+		select {
+		case <-time.After(STATUS_BAR_COLOR_INTERVAL):
+		case <-done:
+			return
+		}
+	}
+}
+
+// Send unless backing off after failures.
+// Warns on the first failure and backs off while it keeps failing.
+// `what` describes the send for debug logs.
+// Returns whether it was sent.
+func (s *StatusBar) trySend(what string, send func() error) bool {
+	if !s.backoff.ready(time.Now()) {
+		logDebug("status bar down, not sending %v", what)
+		return false
+	}
+	err := send()
+	if err != nil {
+		if s.backoff.failed(time.Now()) {
+			logWarn("could not update the status bar, is AnyBar running? %v", err)
+		} else {
+			logDebug("could not send %v to status bar: %v", what, err)
+		}
+		return false
+	}
+	if s.backoff.succeeded() {
+		logInfo("status bar reconnected")
+	}
+	logDebug("status bar sent %v", what)
+	return true
+}
+
+// Close stops the SetLatest worker and closes the TCP connection if there is one.
+func (s *StatusBar) Close() error {
+	s.colorMu.Lock()
+	if s.colorsDone != nil {
+		close(s.colorsDone)
+		s.colorCh = nil
+		s.colorsDone = nil
+	}
+	s.colorMu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// Send one command to AnyBar.
+func (s *StatusBar) send(ctx context.Context, msg string) error {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		timeout := s.Timeout
+		if timeout == 0 {
+			timeout = DEFAULT_STATUS_BAR_TIMEOUT
+		}
+		deadline = time.Now().Add(timeout)
+	}
+	if s.Proto == STATUS_BAR_PROTO_TCP {
+		return s.sendTCP(ctx, deadline, msg)
+	}
+	dialer := net.Dialer{
+		Deadline: deadline,
+	}
+	conn, err := dialer.DialContext(ctx, "udp4", fmt.Sprintf(":%v", s.Port))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(deadline)
+	_, err = conn.Write([]byte(msg))
+	if err != nil {
+		return err
+	}
+
+	// AnyBar never replies, but if nothing is listening the ICMP
+	// port unreachable shows up as a refused read on the connected socket.
+	readDeadline := time.Now().Add(STATUS_BAR_REFUSED_WAIT)
+	if readDeadline.Before(deadline) {
+		conn.SetReadDeadline(readDeadline)
+	}
+	_, err = conn.Read(make([]byte, 1))
+	if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("nothing listening on udp port %v: %v", s.Port, err)
+	}
+	return nil
+}
+
+// Send over the kept open TCP connection, reconnecting once if it has broken.
+func (s *StatusBar) sendTCP(ctx context.Context, deadline time.Time, msg string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if s.conn == nil {
+			dialer := net.Dialer{
+				Deadline: deadline,
+			}
+			s.conn, err = dialer.DialContext(ctx, "tcp4", fmt.Sprintf(":%v", s.Port))
+			if err != nil {
+				s.conn = nil
+				return err
+			}
+		}
+		s.conn.SetWriteDeadline(deadline)
+		_, err = s.conn.Write([]byte(msg + "\n"))
+		if err == nil {
+			return nil
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+	return err
+}
+
+// How long to wait for a refusal after each send.
+const STATUS_BAR_REFUSED_WAIT = 50 * time.Millisecond
+
+// Bounds for how long to stop sending to a status bar after a failure.
+const (
+	STATUS_BAR_MIN_BACKOFF = time.Second
+	STATUS_BAR_MAX_BACKOFF = time.Minute
+)
+
+// statusBarBackoff tracks failed sends so that a status bar that isn't running
+// gets one warning and fewer attempts rather than a failure per update.
+type statusBarBackoff struct {
+	mu      sync.Mutex
+	warned  bool
+	delay   time.Duration
+	retryAt time.Time
+}
+
+// Whether to try sending now.
+func (b *statusBarBackoff) ready(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !now.Before(b.retryAt)
+}
+
+// How long until the next try.
+func (b *statusBarBackoff) wait(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if now.Before(b.retryAt) {
+		return b.retryAt.Sub(now)
+	}
+	return 0
+}
+
+// Record a failed send. Returns true for the first failure since the last success.
+func (b *statusBarBackoff) failed(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case b.delay == 0:
+		b.delay = STATUS_BAR_MIN_BACKOFF
+	case b.delay < STATUS_BAR_MAX_BACKOFF:
+		b.delay *= 2
+		if b.delay > STATUS_BAR_MAX_BACKOFF {
+			b.delay = STATUS_BAR_MAX_BACKOFF
+		}
+	}
+	b.retryAt = now.Add(b.delay)
+	first := !b.warned
+	b.warned = true
+	return first
+}
+
+// Record a successful send. Returns true if it follows failures that were warned about.
+func (b *statusBarBackoff) succeeded() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	recovered := b.warned
+	b.warned = false
+	b.delay = 0
+	b.retryAt = time.Time{}
+	return recovered
+}
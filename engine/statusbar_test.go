@@ -0,0 +1,129 @@
+package engine
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestStatusBarSetLatestCoalesces(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	received := make(chan string, 10)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			received <- scanner.Text()
+		}
+	}()
+
+	s := NewStatusBar(ln.Addr().(*net.TCPAddr).Port)
+	s.Proto = STATUS_BAR_PROTO_TCP
+	defer s.Close()
+	styles := []string{StatusBarBlue, StatusBarOrange, StatusBarBlue, StatusBarOrange, StatusBarRed}
+	for _, style := range styles {
+		s.SetLatest(style)
+	}
+
+	var got []string
+	timeout := time.After(5 * time.Second)
+	for len(got) == 0 || got[len(got)-1] != StatusBarRed {
+		select {
+		case style := <-received:
+			got = append(got, style)
+		case <-timeout:
+			t.Fatalf("never received the latest color, got %v", got)
+		}
+	}
+	// The first color may go out before the rest are requested, the rest are coalesced.
+	if len(got) > 2 {
+		t.Fatalf("sent %v, expected the burst coalesced", got)
+	}
+}
+
+func TestStatusBarKeepAliveRetriesLatest(t *testing.T) {
+	// Find a free port, then leave nothing listening on it.
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	s := NewStatusBar(port)
+	s.Proto = STATUS_BAR_PROTO_TCP
+	s.KeepAlive = true
+	defer s.Close()
+	s.SetLatest(StatusBarOrange)
+	s.SetLatest(StatusBarRed)
+	time.Sleep(100 * time.Millisecond)
+
+	ln, err = net.Listen("tcp4", "127.0.0.1:"+strconv.Itoa(port))
+	if err != nil {
+		t.Skipf("could not listen on the port again: %v", err)
+	}
+	defer ln.Close()
+	received := make(chan string, 10)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			received <- scanner.Text()
+		}
+	}()
+
+	select {
+	case style := <-received:
+		if style != StatusBarRed {
+			t.Fatalf("received %v, expected the latest color", style)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the color was never retried")
+	}
+}
+
+func TestStatusBarTimeout(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	s := NewStatusBar(conn.LocalAddr().(*net.UDPAddr).Port)
+
+	// Already out of time without a deadline from the context.
+	s.Timeout = time.Nanosecond
+	if err := s.Set(context.Background(), StatusBarRed); err == nil {
+		t.Error("expected the send to time out")
+	}
+
+	// A deadline from the context wins.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Set(ctx, StatusBarGreen); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != StatusBarGreen {
+		t.Errorf("received %q", buf[:n])
+	}
+}
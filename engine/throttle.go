@@ -0,0 +1,63 @@
+package engine
+
+import "time"
+
+// buildThrottle limits how often builds start: a token bucket allowing `perMinute`
+// builds a minute, in bursts of up to `perMinute`, and at least `minInterval`
+// between one build starting and the next. A nil buildThrottle allows every build.
+type buildThrottle struct {
+	perMinute int
+	tokens    float64
+	last      time.Time
+
+	minInterval time.Duration
+	// When the last build allowed started, zero before one has.
+	started time.Time
+	// The setting that held back the last take that had to wait,
+	// "MaxBuildsPerMinute" or "MinBuildInterval".
+	heldBy string
+}
+
+// A throttle with a full bucket, or nil for no limit when perMinute and minInterval are 0.
+func newBuildThrottle(perMinute int, minInterval time.Duration, now time.Time) *buildThrottle {
+	if perMinute <= 0 && minInterval <= 0 {
+		return nil
+	}
+	return &buildThrottle{perMinute: perMinute, tokens: float64(perMinute), last: now, minInterval: minInterval}
+}
+
+// Take a token for a build at `now`.
+// Returns 0 if the build may start, or how long until it may.
+func (t *buildThrottle) take(now time.Time) time.Duration {
+	if t == nil {
+		return 0
+	}
+	var wait time.Duration
+	if t.perMinute > 0 {
+		rate := float64(t.perMinute) / float64(time.Minute)
+		t.tokens += float64(now.Sub(t.last)) * rate
+		if t.tokens > float64(t.perMinute) {
+			t.tokens = float64(t.perMinute)
+		}
+		t.last = now
+		if t.tokens < 1 {
+			wait = time.Duration((1 - t.tokens) / rate)
+			t.heldBy = "MaxBuildsPerMinute"
+		}
+	}
+	// A hard floor, unlike debouncing it doesn't move with more changes.
+	if !t.started.IsZero() {
+		if w := t.started.Add(t.minInterval).Sub(now); w > wait {
+			wait = w
+			t.heldBy = "MinBuildInterval"
+		}
+	}
+	if wait > 0 {
+		return wait
+	}
+	if t.perMinute > 0 {
+		t.tokens--
+	}
+	t.started = now
+	return 0
+}
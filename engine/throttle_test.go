@@ -0,0 +1,81 @@
+package engine
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBuildThrottle(t *testing.T) {
+	var none *buildThrottle
+	if wait := none.take(time.Now()); wait != 0 {
+		t.Fatalf("no limit waited %v", wait)
+	}
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	th := newBuildThrottle(6, 0, now)
+	for i := 0; i < 6; i++ {
+		if wait := th.take(now); wait != 0 {
+			t.Fatalf("build %v of a burst waited %v", i+1, wait)
+		}
+	}
+	if wait := th.take(now); wait != 10*time.Second {
+		t.Fatalf("expected to wait 10s after a burst, got %v", wait)
+	}
+	// Asking again doesn't use anything up.
+	now = now.Add(4 * time.Second)
+	if wait := th.take(now); wait != 6*time.Second {
+		t.Fatalf("expected to wait 6s, got %v", wait)
+	}
+	now = now.Add(6 * time.Second)
+	if wait := th.take(now); wait != 0 {
+		t.Fatalf("expected a build after waiting, got %v", wait)
+	}
+	// Refills to at most a minute's worth.
+	now = now.Add(time.Hour)
+	for i := 0; i < 6; i++ {
+		th.take(now)
+	}
+	if wait := th.take(now); wait == 0 {
+		t.Fatal("expected the bucket to hold at most 6 builds")
+	}
+}
+
+func TestBuildThrottleMinInterval(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	th := newBuildThrottle(0, 10*time.Second, now)
+	if wait := th.take(now); wait != 0 {
+		t.Fatalf("first build waited %v", wait)
+	}
+	// Changes don't push the next build back, it's 10s after the last one started.
+	for _, elapsed := range []time.Duration{time.Second, 4 * time.Second, 9 * time.Second} {
+		if wait := th.take(now.Add(elapsed)); wait != 10*time.Second-elapsed {
+			t.Fatalf("%v after a build waited %v, expected %v", elapsed, wait, 10*time.Second-elapsed)
+		}
+		if th.heldBy != "MinBuildInterval" {
+			t.Fatalf("held by %q", th.heldBy)
+		}
+	}
+	now = now.Add(10 * time.Second)
+	if wait := th.take(now); wait != 0 {
+		t.Fatalf("build after the interval waited %v", wait)
+	}
+	if wait := th.take(now.Add(time.Second)); wait != 9*time.Second {
+		t.Fatalf("expected the interval to start over, waited %v", wait)
+	}
+
+	// With both limits the longer wait wins, and waiting uses up no tokens.
+	now = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	th = newBuildThrottle(1, 10*time.Second, now)
+	th.take(now)
+	if wait := th.take(now.Add(5 * time.Second)); wait.Round(time.Millisecond) != 55*time.Second || th.heldBy != "MaxBuildsPerMinute" {
+		t.Fatalf("waited %v held by %q", wait, th.heldBy)
+	}
+	th = newBuildThrottle(60, 10*time.Second, now)
+	th.take(now)
+	if wait := th.take(now.Add(5 * time.Second)); wait != 5*time.Second || th.heldBy != "MinBuildInterval" {
+		t.Fatalf("waited %v held by %q", wait, th.heldBy)
+	}
+	if th.tokens < 58 {
+		t.Fatalf("waiting used up tokens, %v left", th.tokens)
+	}
+}
@@ -0,0 +1,164 @@
+package engine
+
+import (
+	"bufio"
+	"fmt"
+	"os/exec"
+	"path"
+	"sync"
+	"time"
+)
+
+const (
+	// Line fswatch prints after each batch of changed paths.
+	FSWATCH_BATCH_MARKER = "--builderator-batch--"
+)
+
+// Watch a directory or file for changes using the configured WatchBackend.
+// Sends the changed paths into the `ch` once per batch of changes.
+// Returns quick, with a func that stops the watcher.
+func watch(ch chan<- []string, c Config, watchPath string) (func(), error) {
+	if c.WatchBackend == WATCH_BACKEND_POLL {
+		return pollWatch(ch, watchPath, c.PollInterval)
+	}
+	return fswatch(ch, watchPath)
+}
+
+// FSWATCH_INSTALL_HINT tells how to get fswatch or do without it.
+const FSWATCH_INSTALL_HINT = `install it with "brew install fswatch" or "apt install fswatch", or set WatchBackend = "poll"`
+
+// Restarting fswatch after it exits on its own, like it sometimes does after sleep and wake.
+const (
+	// Wait before the first restart, doubling for each restart in a row.
+	FSWATCH_RESTART_BACKOFF = time.Second
+	// Give up after restarting this many times in a row.
+	FSWATCH_MAX_RESTARTS = 5
+	// fswatch that ran this long was working, so the next exit starts the count over.
+	FSWATCH_STABLE_AFTER = time.Minute
+)
+
+// Spawn an fswatch process to watch a directory or file for changes.
+// Restarts it if it exits, giving up after FSWATCH_MAX_RESTARTS in a row.
+// Returns quick, with a func that stops the watcher.
+func fswatch(ch chan<- []string, watchPath string) (func(), error) {
+	bin, err := which("fswatch")
+	if err != nil {
+		return nil, err
+	}
+	if bin == nil {
+		return nil, fmt.Errorf("fswatch not found in PATH, %v", FSWATCH_INSTALL_HINT)
+	}
+	return superviseFSWatch(ch, *bin, watchPath, FSWATCH_RESTART_BACKOFF)
+}
+
+// Run the fswatch at `bin`, restarting it after `backoff` when it exits, and longer each time in a row.
+func superviseFSWatch(ch chan<- []string, bin string, watchPath string, backoff time.Duration) (func(), error) {
+	cmd, exited, err := startFSWatch(ch, bin, watchPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var mu sync.Mutex
+	stopped := false
+	stopCh := make(chan struct{})
+	go func() {
+		restarts := 0
+		wait := backoff
+		started := time.Now()
+		for {
+			err := <-exited
+			mu.Lock()
+			quit := stopped
+			mu.Unlock()
+			if quit {
+				return
+			}
+			if time.Since(started) >= FSWATCH_STABLE_AFTER {
+				restarts = 0
+				wait = backoff
+			}
+			if restarts == FSWATCH_MAX_RESTARTS {
+				// Nothing will be rebuilt from here on, so don't let it pass quietly.
+				logError("fswatch for %v exited %v times in a row, no longer watching for changes: %v", watchPath, restarts+1, err)
+				return
+			}
+			restarts++
+			logWarn("fswatch for %v exited, restarting in %v: %v", watchPath, wait, err)
+			select {
+			case <-time.After(wait):
+			case <-stopCh:
+				return
+			}
+			wait *= 2
+
+			mu.Lock()
+			if stopped {
+				mu.Unlock()
+				return
+			}
+			started = time.Now()
+			next, nextExited, err := startFSWatch(ch, bin, watchPath)
+			if err != nil {
+				// Counts as exiting right away.
+				failed := make(chan error, 1)
+				failed <- err
+				nextExited = failed
+			} else {
+				cmd = next
+				logInfo("fswatch for %v restarted", watchPath)
+			}
+			exited = nextExited
+			mu.Unlock()
+		}
+	}()
+
+	stop := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if stopped {
+			return
+		}
+		stopped = true
+		close(stopCh)
+		cmd.Process.Kill()
+	}
+	return stop, nil
+}
+
+// Start one fswatch process. `exited` gets its exit error once its output is all sent.
+func startFSWatch(ch chan<- []string, bin string, watchPath string) (cmd *exec.Cmd, exited <-chan error, err error) {
+	cmd = exec.Command(bin, watchPath,
+		"--event", "Updated",
+		"--latency", "0.101",
+		"--batch-marker="+FSWATCH_BATCH_MARKER)
+	// watchPath may be a file, like the config file, which can't be a working directory.
+	cmd.Dir = path.Dir(watchPath)
+
+	outReader, err := cmd.StdoutPipe()
+	if err != nil {
+		panic(err)
+	}
+	outScanner := bufio.NewScanner(outReader)
+
+	err = cmd.Start()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		var files []string
+		for outScanner.Scan() {
+			line := outScanner.Text()
+			logDebug("fswatch: %v", line)
+			if line != FSWATCH_BATCH_MARKER {
+				files = append(files, line)
+				continue
+			}
+			ch <- files
+			files = nil
+		}
+		done <- cmd.Wait()
+	}()
+	return cmd, done, nil
+}
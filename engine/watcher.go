@@ -0,0 +1,262 @@
+package engine
+
+import (
+	"context"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Event is one batch of changes from a Watcher.
+type Event struct {
+	// Absolute paths that changed. May be empty for a trigger with no files,
+	// which still starts a build.
+	Paths []string
+}
+
+// Watcher is a source of changes that trigger builds.
+// Set Runner.Watcher to build on something other than WatchDir changing.
+type Watcher interface {
+	// Start watching until ctx is done.
+	// Returns quick, with a channel of batches of changes.
+	Start(ctx context.Context) (<-chan Event, error)
+}
+
+// FSWatchWatcher watches a directory or file with fswatch.
+type FSWatchWatcher struct {
+	Path string
+}
+
+func (w FSWatchWatcher) Start(ctx context.Context) (<-chan Event, error) {
+	return watchEvents(ctx, func(ch chan<- []string) (func(), error) {
+		return fswatch(ch, w.Path)
+	})
+}
+
+// PollWatcher watches a directory or file by walking it every Interval.
+type PollWatcher struct {
+	Path     string
+	Interval time.Duration
+}
+
+func (w PollWatcher) Start(ctx context.Context) (<-chan Event, error) {
+	return watchEvents(ctx, func(ch chan<- []string) (func(), error) {
+		return pollWatch(ch, w.Path, w.Interval)
+	})
+}
+
+// NewWatcher returns the Watcher for a config's WatchDir and ExtraWatchPaths with its WatchBackend.
+func NewWatcher(c Config) Watcher {
+	paths := watchPaths(c)
+	if len(paths) == 1 {
+		return newPathWatcher(c, paths[0])
+	}
+	var ws MultiWatcher
+	for _, p := range paths {
+		ws = append(ws, newPathWatcher(c, p))
+	}
+	return ws
+}
+
+func newPathWatcher(c Config, p string) Watcher {
+	if c.WatchBackend == WATCH_BACKEND_POLL {
+		return PollWatcher{Path: p, Interval: c.PollInterval}
+	}
+	return FSWatchWatcher{Path: p}
+}
+
+// WatchDir and the ExtraWatchPaths that aren't already inside it or each other,
+// so that no change is seen twice.
+func watchPaths(c Config) []string {
+	paths := []string{c.WatchDir}
+	for _, p := range c.ExtraWatchPaths {
+		covered := false
+		for _, q := range paths {
+			if isWithin(p, q) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+// The files builderator writes that are inside the watched paths, for Config.WatchExcludes.
+func watchExcludes(c Config) []string {
+	var written []string
+	for _, p := range []*string{c.StatusFile, c.AggregateStatusFile, c.HeartbeatFile} {
+		if p != nil {
+			written = append(written, *p)
+		}
+	}
+	if c.StatusFile != nil && c.TooltipLines > 0 && c.StatusFormat != STATUS_FORMAT_JSON {
+		written = append(written, TooltipFile(*c.StatusFile))
+	}
+	written = append(written, c.BuildFiles...)
+
+	var excludes []string
+	for _, p := range written {
+		for _, w := range watchPaths(c) {
+			if isWithin(p, w) {
+				excludes = append(excludes, p)
+				break
+			}
+		}
+	}
+	return excludes
+}
+
+// Whether the absolute path p is dir or inside it.
+func isWithin(p string, dir string) bool {
+	rel, err := filepath.Rel(dir, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, "../")
+}
+
+// MultiWatcher combines the changes from several Watchers.
+type MultiWatcher []Watcher
+
+func (ws MultiWatcher) Start(ctx context.Context) (<-chan Event, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	events := make(chan Event)
+	for _, w := range ws {
+		ch, err := w.Start(ctx)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		go func(ch <-chan Event) {
+			for {
+				select {
+				case e, ok := <-ch:
+					if !ok {
+						return
+					}
+					select {
+					case events <- e:
+					case <-ctx.Done():
+						return
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(ch)
+	}
+	// The watchers stop along with ctx, this only releases the derived context.
+	go func() {
+		<-ctx.Done()
+		cancel()
+	}()
+	return events, nil
+}
+
+// Run one of the internal watchers as a channel of Events until ctx is done.
+func watchEvents(ctx context.Context, start func(chan<- []string) (func(), error)) (<-chan Event, error) {
+	ch := make(chan []string)
+	stop, err := start(ch)
+	if err != nil {
+		return nil, err
+	}
+	events := make(chan Event)
+	go func() {
+		defer stop()
+		for {
+			select {
+			case files := <-ch:
+				select {
+				case events <- Event{Paths: files}:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
+
+// watchFilter decides which changed paths from a Watcher can trigger builds.
+type watchFilter struct {
+	ignore *IgnoreRules
+	// Paths that never trigger builds, like Runner.IgnorePaths and Config.WatchExcludes.
+	skip    []string
+	explain explainer
+}
+
+// The paths that aren't ignored or skipped.
+func (f watchFilter) filter(paths []string) []string {
+	var kept []string
+	for _, p := range paths {
+		skipped := false
+		for _, sp := range f.skip {
+			if isWrittenAs(p, sp) {
+				skipped = true
+				break
+			}
+		}
+		if skipped {
+			f.explain.explain([]string{p}, "ignored, written by builderator itself")
+			continue
+		}
+		ignored, pattern := f.ignore.IgnoredBy(p)
+		switch {
+		case ignored:
+			f.explain.explain([]string{p}, "ignored by "+IGNORE_FILE_NAME+" pattern "+strconv.Quote(pattern))
+			continue
+		case pattern != "":
+			f.explain.explain([]string{p}, "re-included by "+IGNORE_FILE_NAME+" pattern "+strconv.Quote(pattern))
+		}
+		kept = append(kept, p)
+	}
+	return kept
+}
+
+// Whether p is the file at `written`, or a temp file writeFileAtomic renames into place there.
+func isWrittenAs(p string, written string) bool {
+	p = filepath.Clean(p)
+	written = filepath.Clean(written)
+	if p == written {
+		return true
+	}
+	return filepath.Dir(p) == filepath.Dir(written) && strings.HasPrefix(filepath.Base(p), "."+filepath.Base(written)+".tmp")
+}
+
+// Send the changes from a Watcher into `ch` until ctx is done,
+// leaving out paths the filter drops and batches with nothing else.
+// Returns a func that stops the watcher.
+func forwardWatcher(ctx context.Context, w Watcher, ch chan<- []string, f watchFilter) (func(), error) {
+	ctx, cancel := context.WithCancel(ctx)
+	events, err := w.Start(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	go func() {
+		for {
+			select {
+			case e, ok := <-events:
+				if !ok {
+					return
+				}
+				paths := f.filter(e.Paths)
+				if len(e.Paths) > 0 && len(paths) == 0 {
+					logDebug("ignoring %v changed files", len(e.Paths))
+					continue
+				}
+				select {
+				case ch <- paths:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return cancel, nil
+}
@@ -0,0 +1,91 @@
+package engine
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWatchPathsSkipsOverlaps(t *testing.T) {
+	c := Config{
+		WatchDir:        "/src/project",
+		ExtraWatchPaths: []string{"/src/project/gen", "/src/schema", "/src/schema/v2", "/src/project-other"},
+	}
+	expected := []string{"/src/project", "/src/schema", "/src/project-other"}
+	if got := watchPaths(c); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("watching %v, expected %v", got, expected)
+	}
+}
+
+func TestRelChangedKeepsOutsidePathsAbsolute(t *testing.T) {
+	c := Config{WatchDir: "/src/project"}
+	got := relChanged(c, []string{"/src/project/main.go", "/src/schema/a.proto"})
+	expected := []string{"main.go", "/src/schema/a.proto"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("got %v, expected %v", got, expected)
+	}
+}
+
+func TestSuperviseFSWatchRestarts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "builderator-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// Reports one change and then crashes, every time.
+	bin := filepath.Join(dir, "fswatch")
+	script := "#!/bin/sh\necho \"$1/a.go\"\necho " + FSWATCH_BATCH_MARKER + "\nexit 1\n"
+	if err := ioutil.WriteFile(bin, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan []string)
+	stop, err := superviseFSWatch(ch, bin, dir, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+	for i := 0; i < 1+FSWATCH_MAX_RESTARTS; i++ {
+		select {
+		case files := <-ch:
+			if !reflect.DeepEqual(files, []string{filepath.Join(dir, "a.go")}) {
+				t.Fatalf("got %v", files)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("fswatch was not restarted after exiting %v times", i)
+		}
+	}
+	// Then it gives up.
+	select {
+	case files := <-ch:
+		t.Fatalf("restarted more than %v times, got %v", FSWATCH_MAX_RESTARTS, files)
+	case <-time.After(1 * time.Second):
+	}
+}
+
+func TestWatchExcludes(t *testing.T) {
+	status := "/src/project/.status"
+	heartbeat := "/tmp/heartbeat"
+	c := Config{
+		WatchDir:        "/src/project",
+		ExtraWatchPaths: []string{"/src/schema"},
+		StatusFile:      &status,
+		StatusFormat:    STATUS_FORMAT_TEXT,
+		TooltipLines:    5,
+		HeartbeatFile:   &heartbeat,
+		BuildFiles:      []string{"/src/project/bin/app", "/src/schema/gen", "/usr/local/bin/app"},
+	}
+	expected := []string{"/src/project/.status", "/src/project/.status.tail", "/src/project/bin/app", "/src/schema/gen"}
+	if got := watchExcludes(c); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("excluding %v, expected %v", got, expected)
+	}
+
+	f := watchFilter{skip: expected}
+	changed := []string{"/src/project/main.go", "/src/project/.status", "/src/project/..status.tmp123", "/src/project/.status.tmp123"}
+	if got := f.filter(changed); !reflect.DeepEqual(got, []string{"/src/project/main.go", "/src/project/.status.tmp123"}) {
+		t.Fatalf("kept %v", got)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Answers gathered by `builderator init`.
+type initAnswers struct {
+	WatchDir      string
+	BuildCmd      string
+	StatusFile    string
+	StatusBarPort int
+}
+
+// Defaults for the init prompts in a project directory.
+// Uses the detected language's starter if there is one.
+func initDefaults(dir string) initAnswers {
+	a := initAnswers{
+		WatchDir: ".",
+		BuildCmd: "go install",
+	}
+	lang, _ := detectLang(dir)
+	if s, ok := langStarters[lang]; ok {
+		a.WatchDir = s.WatchDir
+		a.BuildCmd = s.BuildCmd
+	}
+	return a
+}
+
+// Prompt on `out` for each config value and read answers from `in`.
+// An empty answer takes the default. Invalid answers are asked again.
+// Relative paths are checked relative to `dir`.
+func promptInit(in io.Reader, out io.Writer, dir string, defaults initAnswers) (initAnswers, error) {
+	r := bufio.NewReader(in)
+	ask := func(question string, def string, check func(string) error) (string, error) {
+		for {
+			if def == "" {
+				fmt.Fprintf(out, "%v: ", question)
+			} else {
+				fmt.Fprintf(out, "%v [%v]: ", question, def)
+			}
+			line, err := r.ReadString('\n')
+			if err != nil && (err != io.EOF || line == "") {
+				return "", fmt.Errorf("no answer for %v: %v", question, err)
+			}
+			answer := strings.TrimSpace(line)
+			if answer == "" {
+				answer = def
+			}
+			err = check(answer)
+			if err == nil {
+				return answer, nil
+			}
+			fmt.Fprintf(out, "  %v\n", err)
+		}
+	}
+
+	var a initAnswers
+	var err error
+	a.WatchDir, err = ask("Directory to watch", defaults.WatchDir, func(s string) error {
+		p := s
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(dir, p)
+		}
+		info, err := os.Stat(p)
+		switch {
+		case os.IsNotExist(err):
+			return fmt.Errorf("%v does not exist", s)
+		case err != nil:
+			return err
+		case !info.IsDir():
+			return fmt.Errorf("%v is not a directory", s)
+		}
+		return nil
+	})
+	if err != nil {
+		return a, err
+	}
+	a.BuildCmd, err = ask("Command to run when files change", defaults.BuildCmd, func(s string) error {
+		if s == "" {
+			return fmt.Errorf("a command is required")
+		}
+		return nil
+	})
+	if err != nil {
+		return a, err
+	}
+	a.StatusFile, err = ask("Status file (optional)", defaults.StatusFile, func(s string) error {
+		return nil
+	})
+	if err != nil {
+		return a, err
+	}
+	defPort := ""
+	if defaults.StatusBarPort > 0 {
+		defPort = strconv.Itoa(defaults.StatusBarPort)
+	}
+	port, err := ask("AnyBar UDP port (optional)", defPort, func(s string) error {
+		if s == "" {
+			return nil
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("%v is not a port number", s)
+		}
+		return nil
+	})
+	if err != nil {
+		return a, err
+	}
+	if port != "" {
+		a.StatusBarPort, _ = strconv.Atoi(port)
+	}
+	return a, nil
+}
+
+// Render init answers as a config file.
+func (a initAnswers) config() string {
+	var b strings.Builder
+	b.WriteString("# All relative paths are relative to this config file.\n")
+	b.WriteString("# See example.toml in the builderator repo for every option.\n\n")
+	fmt.Fprintf(&b, "WatchDir = %q\n", a.WatchDir)
+	fmt.Fprintf(&b, "BuildCmd = %q\n", a.BuildCmd)
+	if a.StatusFile != "" {
+		fmt.Fprintf(&b, "StatusFile = %q\n", a.StatusFile)
+	}
+	if a.StatusBarPort > 0 {
+		fmt.Fprintf(&b, "StatusBarPort = %v\n", a.StatusBarPort)
+	}
+	return b.String()
+}
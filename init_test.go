@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mlsteele/builderator/engine"
+)
+
+func TestPromptInitDefaults(t *testing.T) {
+	dir, err := ioutil.TempDir("", "builderator-init")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defaults := initAnswers{WatchDir: ".", BuildCmd: "make"}
+	var out bytes.Buffer
+	a, err := promptInit(strings.NewReader("\n\n\n\n"), &out, dir, defaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a != defaults {
+		t.Fatalf("got %+v, expected the defaults %+v", a, defaults)
+	}
+}
+
+func TestPromptInitRetriesInvalidAnswers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "builderator-init")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = os.Mkdir(filepath.Join(dir, "src"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := strings.Join([]string{
+		"nope",   // WatchDir that doesn't exist
+		"src",    // WatchDir
+		"make",   // BuildCmd
+		"status", // StatusFile
+		"99999",  // StatusBarPort out of range
+		"1738",   // StatusBarPort
+	}, "\n") + "\n"
+	var out bytes.Buffer
+	a, err := promptInit(strings.NewReader(input), &out, dir, initAnswers{WatchDir: "."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := initAnswers{WatchDir: "src", BuildCmd: "make", StatusFile: "status", StatusBarPort: 1738}
+	if a != expected {
+		t.Fatalf("got %+v, expected %+v", a, expected)
+	}
+	for _, msg := range []string{"nope does not exist", "99999 is not a port number"} {
+		if !strings.Contains(out.String(), msg) {
+			t.Errorf("output %q does not mention %q", out.String(), msg)
+		}
+	}
+
+	// The answers should make a config that reads back cleanly.
+	cpath := filepath.Join(dir, CONF_NAME)
+	err = ioutil.WriteFile(cpath, []byte(a.config()), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := engine.ReadConfig(cpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.WatchDir != filepath.Join(dir, "src") || c.StatusBarPort != 1738 {
+		t.Fatalf("config read back as %+v", c)
+	}
+}
+
+func TestPromptInitEndOfInput(t *testing.T) {
+	var out bytes.Buffer
+	_, err := promptInit(strings.NewReader(""), &out, ".", initAnswers{WatchDir: "."})
+	if err == nil {
+		t.Fatal("expected an error when input runs out")
+	}
+}
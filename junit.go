@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/mlsteele/builderator/engine"
+)
+
+// Just enough of the JUnit XML schema for CI dashboards to show one build.
+type junitTestSuite struct {
+	XMLName  xml.Name        `xml:"testsuite"`
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Output  string `xml:",chardata"`
+}
+
+// Write a build result to path as a JUnit XML file with a single testcase.
+func writeJUnit(path string, c engine.Config, res engine.BuildResult) error {
+	secs := fmt.Sprintf("%.3f", res.Duration.Seconds())
+	tc := junitTestCase{
+		Name:      c.BuildCmdString(),
+		Classname: "builderator",
+		Time:      secs,
+	}
+	suite := junitTestSuite{
+		Name:  "builderator",
+		Tests: 1,
+		Time:  secs,
+	}
+	if res.Error != nil {
+		tc.Failure = &junitFailure{
+			Message: res.Error.Error(),
+			Output:  res.Output(),
+		}
+		suite.Failures = 1
+	}
+	suite.Cases = []junitTestCase{tc}
+
+	b, err := xml.MarshalIndent(suite, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append([]byte(xml.Header), b...)
+	return ioutil.WriteFile(path, append(b, '\n'), 0644)
+}
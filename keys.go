@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Listen for single keypresses on stdin.
+// 'r' calls `rebuild`.
+// 'q' sends an interrupt into `sigCh` to quit the same way SIGINT does.
+// Puts the terminal in cbreak mode; returns a func that restores it.
+func readKeys(rebuild func(), sigCh chan<- os.Signal) (func(), error) {
+	saved, err := stty("-g")
+	if err != nil {
+		return nil, err
+	}
+	_, err = stty("-icanon", "-echo", "min", "1")
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		r := bufio.NewReader(os.Stdin)
+		for {
+			b, err := r.ReadByte()
+			if err != nil {
+				return
+			}
+			switch b {
+			case 'r':
+				logInfo("rebuild requested")
+				rebuild()
+			case 'q':
+				select {
+				case sigCh <- os.Interrupt:
+				default:
+					// Already shutting down.
+				}
+				return
+			}
+		}
+	}()
+
+	restore := func() {
+		stty(saved)
+	}
+	return restore, nil
+}
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
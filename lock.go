@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Lock is an advisory lock guarding a config against multiple builderators.
+// The lock file lives next to the config and records the holder's PID.
+type Lock struct {
+	f *os.File
+}
+
+type LockHeldError struct {
+	Path string
+	// PID recorded by the holder. 0 if it could not be read.
+	Pid int
+}
+
+func (e LockHeldError) Error() string {
+	if e.Pid == 0 {
+		return fmt.Sprintf("another builderator holds the lock %v", e.Path)
+	}
+	return fmt.Sprintf("another builderator (pid %v) holds the lock %v", e.Pid, e.Path)
+}
+
+// LockPath returns the path of the lock file for the config at cpath,
+// or for one of its tasks if `task` isn't empty.
+// Named after the config so differently named configs in one directory
+// don't share a lock: .builderator.toml locks .builderator.lock,
+// and its task "web" locks .builderator.web.lock.
+func LockPath(cpath string, task string) string {
+	name := strings.TrimSuffix(path.Base(cpath), ".toml")
+	if task != "" {
+		name += "." + task
+	}
+	return path.Join(path.Dir(cpath), name+".lock")
+}
+
+// AcquireLock takes the lock for the config at cpath, or one of its tasks, without blocking.
+// Returns a LockHeldError if another live process holds it.
+func AcquireLock(cpath string, task string) (*Lock, error) {
+	lpath := LockPath(cpath, task)
+	f, err := os.OpenFile(lpath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err != nil {
+		pid, _ := readLockPid(f)
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, LockHeldError{Path: lpath, Pid: pid}
+		}
+		return nil, fmt.Errorf("could not lock %v: %v", lpath, err)
+	}
+
+	// The kernel drops a flock when its holder dies, so a PID left in the file
+	// means the previous instance did not exit cleanly.
+	if pid, err := readLockPid(f); err == nil && pid != 0 && !pidAlive(pid) {
+		logInfo("Taking over lock from dead pid %v", pid)
+	}
+
+	err = f.Truncate(0)
+	if err == nil {
+		_, err = f.WriteAt([]byte(fmt.Sprintf("%d\n", os.Getpid())), 0)
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("could not record pid in %v: %v", lpath, err)
+	}
+
+	return &Lock{f: f}, nil
+}
+
+// Release clears the pid from the lock file and drops the lock.
+// The file stays: removing it would let one instance flock the unlinked file
+// while another creates and locks a new one at the same path.
+// Safe to call on a nil or already released Lock.
+func (l *Lock) Release() {
+	if l == nil || l.f == nil {
+		return
+	}
+	l.f.Truncate(0)
+	l.f.Close()
+	l.f = nil
+}
+
+// LockHolder returns the PID of the builderator holding the lock for the config at cpath,
+// or one of its tasks. Returns 0 if nothing holds it.
+func LockHolder(cpath string, task string) (int, error) {
+	lpath := LockPath(cpath, task)
+	f, err := os.Open(lpath)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_SH|syscall.LOCK_NB)
+	if err == nil {
+		// Left behind by an instance that died.
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		return 0, nil
+	}
+	if err != syscall.EWOULDBLOCK {
+		return 0, fmt.Errorf("could not check lock %v: %v", lpath, err)
+	}
+	pid, err := readLockPid(f)
+	if err != nil || pid == 0 {
+		return 0, fmt.Errorf("lock %v is held but has no pid in it", lpath)
+	}
+	return pid, nil
+}
+
+func readLockPid(f *os.File) (int, error) {
+	_, err := f.Seek(0, 0)
+	if err != nil {
+		return 0, err
+	}
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return 0, err
+	}
+	s := strings.TrimSpace(string(b))
+	if len(s) == 0 {
+		return 0, nil
+	}
+	return strconv.Atoi(s)
+}
+
+// pidAlive reports whether a process with the given pid exists.
+func pidAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLockHolder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "builderator-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cpath := filepath.Join(dir, ".builderator.toml")
+
+	pid, err := LockHolder(cpath, "")
+	if err != nil || pid != 0 {
+		t.Fatalf("expected no holder without a lock file, got %v %v", pid, err)
+	}
+
+	lock, err := AcquireLock(cpath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err = LockHolder(cpath, "")
+	if err != nil || pid != os.Getpid() {
+		t.Fatalf("expected this process to hold the lock, got %v %v", pid, err)
+	}
+	if pid, _ := LockHolder(cpath, "web"); pid != 0 {
+		t.Fatalf("expected no holder for another task, got %v", pid)
+	}
+	lock.Release()
+	pid, err = LockHolder(cpath, "")
+	if err != nil || pid != 0 {
+		t.Fatalf("expected no holder after release, got %v %v", pid, err)
+	}
+	lock, err = AcquireLock(cpath, "")
+	if err != nil {
+		t.Fatalf("could not take the lock again after release: %v", err)
+	}
+	lock.Release()
+
+	// A lock file left by an instance that died isn't held.
+	err = ioutil.WriteFile(LockPath(cpath, ""), []byte("12345\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err = LockHolder(cpath, "")
+	if err != nil || pid != 0 {
+		t.Fatalf("expected a stale lock to have no holder, got %v %v", pid, err)
+	}
+}
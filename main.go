@@ -1,29 +1,37 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
 	"context"
-	"errors"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
-	"log"
+	"net"
+	"net/http"
 	"os"
 	"os/exec"
-	"os/user"
+	"os/signal"
 	"path"
 	"path/filepath"
-	"runtime"
+	"strconv"
 	"strings"
-	"sync"
 	"syscall"
 
-	"github.com/BurntSushi/toml"
+	"github.com/mlsteele/builderator/engine"
 )
 
 const (
 	CONF_NAME = ".builderator.toml"
+
+	// Environment variable that overrides CONF_NAME.
+	CONF_NAME_ENV = "BUILDERATOR_CONFIG_NAME"
+
+	// How many directories up from cwd to search for a config.
+	DEFAULT_SEARCH_DEPTH = 64
+
+	// Exit code of -o -wait when interrupted before a build finished, like a shell's for SIGINT.
+	WAIT_INTERRUPTED_EXIT_CODE = 130
 )
 
 // See example.toml for config specs.
@@ -49,64 +57,61 @@ BuildFile   = "~/go/bin/builderator"
 StatusBarPort = 1738
 `
 
-// RawConfig is the config before validation.
-// All paths are absolute or relative to the config file.
-type RawConfig struct {
-	WatchDir      *string
-	BuildCmd      *string
-	BuildCmdDir   *string
-	StatusFile    *string
-	BuildFile     *string
-	StatusBarPort int
-}
-
-// Validated config. All paths are absolute.
-type Config struct {
-	// Absolute path to the config file.
-	ConfigPath string
-
-	WatchDir      string
-	BuildCmd      string
-	BuildCmdDir   string
-	StatusFile    *string
-	BuildFile     *string
-	StatusBarPort int
+type ConfigNotFoundError struct {
+	Name string
+	// How many directories were searched for it.
+	Depth int
 }
 
-type BuildResult struct {
-	Error  error
-	Output string
+func NewConfigNotFoundError(name string, depth int) error {
+	return ConfigNotFoundError{Name: name, Depth: depth}
 }
 
-type ConfigNotFoundError struct{}
-
-func NewConfigNotFoundError() error {
-	return ConfigNotFoundError{}
+func (e ConfigNotFoundError) Error() string {
+	if e.Depth == 1 {
+		return fmt.Sprintf("no config file (%v) found in cwd (search depth 1)", e.Name)
+	}
+	return fmt.Sprintf("no config file (%v) found in cwd or its parents (search depth %v)", e.Name, e.Depth)
 }
 
-func (e ConfigNotFoundError) Error() string {
-	return fmt.Sprintf("no config file (%v) found", CONF_NAME)
+// The config file name to search for.
+// The -name flag wins over $BUILDERATOR_CONFIG_NAME which wins over CONF_NAME.
+func configName(flagName string) string {
+	if len(flagName) > 0 {
+		return flagName
+	}
+	if env := os.Getenv(CONF_NAME_ENV); len(env) > 0 {
+		return env
+	}
+	return CONF_NAME
 }
 
 // Find the absolute path to a config file.
-// Walks up the filesystem looking for a file named CONF_NAME
+// Walks up the filesystem looking for a file named `name`.
 // `limit` is how many directories up to search. 1 only looks in cwd.
-func FindConfig(limit int) (string, error) {
+// With `trace` it logs each directory it looks in and where it found the config.
+func FindConfig(name string, limit int, trace bool) (string, error) {
 	cwd, err := os.Getwd()
 	if err != nil {
 		return "", err
 	}
 	dir := cwd
 	prev := cwd + "hack"
+	depth := limit
 	for {
 		limit--
 		if limit < 0 || dir == prev {
-			return "", NewConfigNotFoundError()
+			return "", NewConfigNotFoundError(name, depth)
+		}
+		if trace {
+			logInfo("looking in: %v", dir)
 		}
-		// logInfo("@@@ looking in: %v\n", dir)
-		cpath := path.Join(dir, CONF_NAME)
+		cpath := path.Join(dir, name)
 		stat, err := os.Stat(cpath)
 		if err == nil && !stat.IsDir() {
+			if trace {
+				logInfo("found %v, %v directories up from %v", cpath, depth-limit-1, cwd)
+			}
 			return cpath, nil
 		}
 		prev = dir
@@ -114,135 +119,21 @@ func FindConfig(limit int) (string, error) {
 	}
 }
 
-func ReadConfig(cpath string) (Config, error) {
-	var rc RawConfig
-	var c Config
-
-	if !path.IsAbs(cpath) {
-		return c, fmt.Errorf("config path must be absolute: %v", cpath)
-	}
-
-	_, err := toml.DecodeFile(cpath, &rc)
-	if err != nil {
-		return c, err
-	}
-
-	c.ConfigPath = path.Clean(cpath)
-	confdir := path.Dir(c.ConfigPath)
-
-	if rc.WatchDir == nil {
-		return c, fmt.Errorf("missing required config value: WatchDir")
-	}
-	c.WatchDir, err = RerootPath(*rc.WatchDir, confdir)
-	if err != nil {
-		return c, err
-	}
-
-	if rc.BuildCmd == nil {
-		return c, fmt.Errorf("missing required config value: BuildCmd")
-	}
-	c.BuildCmd = *rc.BuildCmd
-
-	c.BuildCmdDir = confdir
-	if rc.BuildCmdDir != nil {
-		c.BuildCmdDir, err = RerootPath(*rc.BuildCmdDir, confdir)
-		if err != nil {
-			return c, err
-		}
-	}
-
-	if rc.StatusFile != nil {
-		s, err := RerootPath(*rc.StatusFile, confdir)
-		if err != nil {
-			return c, err
-		}
-		c.StatusFile = &s
-	}
-
-	if rc.BuildFile != nil {
-		s, err := RerootPath(*rc.BuildFile, confdir)
-		if err != nil {
-			return c, err
-		}
-		c.BuildFile = &s
-	}
-
-	c.StatusBarPort = rc.StatusBarPort
-
-	return c, nil
-}
-
-func PrintConfig(c Config) {
-	pf := func(a string, b string) {
-		logInfo("%s:\n  %s\n", a, b)
-	}
-	pfo := func(a string, b *string) {
-		if b == nil {
-			logInfo("%s: None\n", a)
-		} else {
-			pf(a, *b)
-		}
-	}
-	pf("WatchDir", c.WatchDir)
-	pf("BuildCmd", c.BuildCmd)
-	pf("BuildCmdDir", c.BuildCmdDir)
-	pfo("StatusFile", c.StatusFile)
-	pfo("BuildFile", c.BuildFile)
-}
-
-// RerootPath takes a path and makes sure it's absolute.
-// If it was relative, it is treated as relative to relto.
-func RerootPath(p string, relto string) (string, error) {
-	var err error
-	p, err = Homeopathy(p)
-	if err != nil {
-		return "", err
-	}
-	p = os.ExpandEnv(p)
-	if !path.IsAbs(p) {
-		p = path.Join(relto, p)
-	}
-	p = path.Clean(p)
-	return p, nil
-}
-
-// Homeopathy takes a path and expands the ~ part of it if there is one.
-// It is not always possible to do this, or so they say.
-func Homeopathy(p string) (string, error) {
-	homefirst := func(q string) (string, error) {
-		usr, err := user.Current()
-		if err != nil {
-			return "", err
-		}
-		dir := usr.HomeDir
-		if len(dir) == 0 {
-			return "", errors.New("no user homedir set")
-		}
-		return path.Join(dir, q), nil
-	}
-
-	switch {
-	case len(p) == 1 && p == "~":
-		return homefirst("")
-	case len(p) >= 2 && p[:2] == "~/":
-		return homefirst(p[2:])
-	}
-
-	return p, nil
-}
-
 func usage() {
-	logInfo("Usage: %s\n       %s mon\n", os.Args[0], os.Args[0])
+	logInfo("Usage: %s\n       %s mon\n       %s validate\n       %s history\n       %s status\n       %s stop\n       %s init [-yes]\n", os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0])
 	flag.PrintDefaults()
 }
 
 func main() {
 	var app App
 	app.main()
+	os.Exit(app.exitCode)
 }
 
 type App struct {
-	statusBar *StatusBar
+	// One for each task being run.
+	locks    []*Lock
+	exitCode int
 }
 
 func (a *App) main() {
@@ -253,28 +144,147 @@ func (a *App) main() {
 	var cpath0 string
 	flag.StringVar(&cpath0, "c", "", "Config file path")
 	var generateStarter bool
-	flag.BoolVar(&generateStarter, "g", false, "Generate: create a .builderator.toml with a default config")
+	flag.BoolVar(&generateStarter, "g", false, "Generate: create a .builderator.toml (or -name) with a default config. -g - prints it to stdout instead")
+	var lang string
+	flag.StringVar(&lang, "lang", "", fmt.Sprintf("With -g, generate a starter config for a language: %v", strings.Join(starterLangs(), ", ")))
+	var name string
+	flag.StringVar(&name, "name", "", fmt.Sprintf("Config file name to search for (default %v, or $%v)", CONF_NAME, CONF_NAME_ENV))
+	var searchDepth int
+	flag.IntVar(&searchDepth, "search-depth", DEFAULT_SEARCH_DEPTH, "How many directories up from cwd to search for the config. 1 only looks in cwd")
+	var traceConfig bool
+	flag.BoolVar(&traceConfig, "trace-config", false, "Log each directory searched for the config and where it was found")
 	var dryrun bool
 	flag.BoolVar(&dryrun, "n", false, "Dryrun: print parsed config and exit")
+	var dryrunJSON bool
+	flag.BoolVar(&dryrunJSON, "json", false, "With -n, print the resolved config as JSON instead")
+	var printCommand bool
+	flag.BoolVar(&printCommand, "print-effective-command", false, "Print exactly what a build runs, with its directory and added environment, and exit")
+	var force bool
+	flag.BoolVar(&force, "force", false, "Force: Only warn when WatchDir or BuildCmdDir don't exist")
+	var buildCmd string
+	flag.StringVar(&buildCmd, "cmd", "", "Command: Run this instead of the config's BuildCmd")
+	var junitPath string
+	flag.StringVar(&junitPath, "junit", "", "JUnit: With -o, write the build result to this path as JUnit XML")
+	var verbose bool
+	flag.BoolVar(&verbose, "v", false, "Verbose: also log changed files, watcher events, build lifecycle and status bar sends")
+	var quiet bool
+	flag.BoolVar(&quiet, "q", false, "Quiet: only log warnings and build failures")
+	var logFormat string
+	flag.StringVar(&logFormat, "log-format", engine.LOG_FORMAT_TEXT, "Log format: text, or json for one object per line")
+	var showVersion bool
+	flag.BoolVar(&showVersion, "version", false, "Print the version and exit")
 	var once bool
 	flag.BoolVar(&once, "o", false, "Once: Run the build command once and exit")
-	// TODO add flag --quiet silences the output unless there's an error
+	var wait bool
+	flag.BoolVar(&wait, "wait", false, "With -o, build on the next change instead of right away, then exit with the build's exit code")
+	var clearScreen bool
+	flag.BoolVar(&clearScreen, "clear", false, "Clear: Clear the terminal before each build, like ClearScreen")
+	var explain bool
+	flag.BoolVar(&explain, "explain", false, "Explain: log why each changed file did or didn't start a build")
+	var watchOnly bool
+	flag.BoolVar(&watchOnly, "watch-only", false, "Watch only: log every change with its files but never build")
+	var taskNames string
+	flag.StringVar(&taskNames, "task", "", "Task: Only run the named [[Task]]s of the config, separated by commas")
+	var daemon bool
+	flag.BoolVar(&daemon, "daemon", false, "Daemon: Run in the background, logging to -log-file. Stop it with builderator stop. Not on Windows")
+	var logFile string
+	flag.StringVar(&logFile, "log-file", "", "With -daemon, append logs to this file (default next to the config, like .builderator.log)")
 
 	flag.Parse()
 
+	if showVersion {
+		fmt.Println(versionString())
+		return
+	}
+
+	name = configName(name)
+	err := engine.SetLogFormat(logFormat)
+	if err != nil {
+		die(err.Error())
+	}
+	switch {
+	case verbose && quiet:
+		die("-v and -q are opposites, pick one")
+	case verbose:
+		engine.SetLogLevel(engine.LOG_DEBUG)
+	case quiet:
+		engine.SetLogLevel(engine.LOG_QUIET)
+	}
+	if searchDepth < 1 {
+		die("-search-depth must be at least 1")
+	}
+	if junitPath != "" && !once {
+		die("-junit requires -o")
+	}
+	if wait && !once {
+		die("-wait requires -o")
+	}
+	if watchOnly && once {
+		die("-watch-only never builds, so -o would never exit")
+	}
+	if logFile != "" && !daemon {
+		die("-log-file requires -daemon")
+	}
+	if daemon && once {
+		die("-daemon runs until stopped, it can't be used with -o")
+	}
+
 	mon := false
+	validate := false
+	history := false
+	status := false
+	stop := false
+	generateToStdout := false
+	runInit := false
 
 	switch {
 	case flag.NArg() == 0:
+	case flag.NArg() == 1 && flag.Arg(0) == "-" && generateStarter:
+		generateToStdout = true
 	case flag.NArg() == 1 && flag.Arg(0) == "mon":
 		mon = true
+	case flag.NArg() == 1 && flag.Arg(0) == "validate":
+		validate = true
+	case flag.NArg() == 1 && flag.Arg(0) == "history":
+		history = true
+	case flag.NArg() == 1 && flag.Arg(0) == "status":
+		status = true
+	case flag.NArg() == 1 && flag.Arg(0) == "stop":
+		stop = true
+	case flag.NArg() >= 1 && flag.Arg(0) == "init":
+		runInit = true
 	default:
 		usage()
 		die("Incorrect usage")
 	}
 
+	if runInit {
+		initFlags := flag.NewFlagSet("init", flag.ExitOnError)
+		yes := initFlags.Bool("yes", false, "Accept the defaults without prompting")
+		initFlags.Parse(flag.Args()[1:])
+		err := initConfig(name, *yes)
+		if err != nil {
+			die(fmt.Sprintf("Could not create config: %v", err))
+		}
+		return
+	}
+
+	if lang != "" && !generateStarter {
+		die("-lang requires -g")
+	}
+	if generateStarter && lang == "" {
+		lang = detectStarterLang()
+	}
+	if generateStarter && generateToStdout {
+		starter, err := starterConfig(lang)
+		if err != nil {
+			die(fmt.Sprintf("Could not generate config: %v", err))
+		}
+		fmt.Print(starter)
+		return
+	}
 	if generateStarter {
-		err := generate()
+		err := generate(name, lang)
 		if err != nil {
 			die(fmt.Sprintf("Could not generate config: %v\n", err))
 		}
@@ -283,11 +293,11 @@ func (a *App) main() {
 
 	var cpath string
 	if len(cpath0) == 0 {
-		foundpath, err := FindConfig(64)
+		foundpath, err := FindConfig(name, searchDepth, traceConfig)
 		switch err := err.(type) {
 		case nil:
 		case ConfigNotFoundError:
-			fmt.Fprintf(os.Stderr, "%v\nTo generate a template run: builderator -g\n", err)
+			logErr("%v\nTo generate a template run: builderator -g", err)
 			os.Exit(1)
 		default:
 			die(fmt.Sprintf("Could not find config file: %v\n", err))
@@ -298,360 +308,653 @@ func (a *App) main() {
 		if err != nil {
 			die(fmt.Sprintf("Could not get cwd"))
 		}
-		cpath, err = RerootPath(cpath0, cwd)
+		cpath, err = engine.RerootPath(cpath0, cwd)
 		if err != nil {
 			die(fmt.Sprintf("Could not find config file: %v\n", err))
 		}
 	}
 
-	c, err := ReadConfig(cpath)
+	if validate {
+		if !validateConfig(cpath) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	cs, err := engine.ReadTasks(cpath)
+	// Stopping doesn't need the directories, only the lock files next to the config.
+	if force || stop {
+		err = warnDirErrors(err)
+	}
 	if err != nil {
 		die2("Could not read config file", err)
 	}
+	cs, err = selectTasks(cs, taskNames)
+	if err != nil {
+		die(err.Error())
+	}
+	for _, c := range cs {
+		for _, err := range configWarnings(c) {
+			logWarn("%v", err)
+		}
+	}
 
 	if mon {
-		monitor(c)
+		if len(cs) > 1 {
+			die("mon watches one task, pick it with -task")
+		}
+		monitor(cs[0])
 		return
 	}
 
-	switch flag.NArg() {
-	case 0:
-	case 1:
-		if flag.Arg(0) == "mon" {
-			die("mon not implemented")
-		} else {
-			usage()
-			die("Incorrect usage")
+	if history {
+		for _, c := range cs {
+			if len(cs) > 1 {
+				logInfo("Task %v:", c.Task)
+			}
+			err := printHistory(c)
+			if err != nil {
+				die2("Could not get history", err)
+			}
 		}
+		return
 	}
 
-	if c.StatusBarPort > 0 {
-		a.statusBar = NewStatusBar(c.StatusBarPort)
+	if stop {
+		err := stopRunning(cs)
+		if err != nil {
+			die(err.Error())
+		}
+		return
 	}
-	a.setStatusBar(StatusBarBlue)
 
-	if dryrun {
-		fmt.Fprintf(os.Stderr, "Config path:\n  %v\n", cpath)
-		PrintConfig(c)
-		fmt.Fprintf(os.Stderr, "\nDryrun complete\n")
+	if status {
+		for _, c := range cs {
+			if len(cs) > 1 {
+				logInfo("Task %v:", c.Task)
+			}
+			printStatusFile(c)
+		}
 		return
 	}
 
-	PrintConfig(c)
-
-	watchCh := make(chan struct{})
-	err = watch(watchCh, c.WatchDir)
-	if err != nil {
-		die(fmt.Sprintf("Could not start watcher: %v\n", err))
+	if dryrunJSON && !dryrun {
+		die("-json requires -n")
+	}
+	if dryrun && dryrunJSON {
+		err := printConfigJSON(cs)
+		if err != nil {
+			die2("Could not print config", err)
+		}
 		return
 	}
 
-	if c.StatusFile != nil {
-		writeStatus(*c.StatusFile, "BUILDING")
-		a.setStatusBar(StatusBarBlue)
+	if dryrun {
+		fmt.Fprintf(os.Stderr, "Config path:\n  %v\n", cpath)
+		if cs[0].GlobalConfigPath != "" {
+			fmt.Fprintf(os.Stderr, "Global config path (overridden by config path):\n  %v\n", cs[0].GlobalConfigPath)
+		}
+		for _, c := range cs {
+			engine.PrintConfig(c)
+		}
+		if buildCmd != "" {
+			fmt.Fprintf(os.Stderr, "BuildCmd overridden by -cmd\n")
+		}
+		fmt.Fprintf(os.Stderr, "\nDryrun complete\n")
+		return
 	}
-	buildResultCh, abortCh := build(c)
-	active := true
 
-	for {
-		select {
-		case <-watchCh:
-			logInfo("files changed")
-			if active {
-				abortCh <- struct{}{}
-
-				if c.StatusFile != nil {
-					writeStatus(*c.StatusFile, "CANCELING")
-					a.setStatusBar(StatusBarOrange)
-				}
-
-				// Wait for the abort to effect.
-				res := <-buildResultCh
-				err := a.report(c, res)
-				if err != nil {
-					log.Print(err)
-				}
-				if once {
-					return
-				}
+	if printCommand {
+		for _, c := range cs {
+			if len(cs) > 1 {
+				fmt.Printf("Task %v:\n", c.Task)
 			}
-
-			if c.StatusFile != nil {
-				writeStatus(*c.StatusFile, "BUILDING")
-				a.setStatusBar(StatusBarBlue)
-			}
-			buildResultCh, abortCh = build(c)
-			active = true
-		case res := <-buildResultCh:
-			err := a.report(c, res)
+			runner := engine.NewRunner(c)
+			runner.BuildCmd = buildCmd
+			err := engine.WriteEffectiveCommands(os.Stdout, runner.Config())
 			if err != nil {
-				log.Print(err)
-			}
-			active = false
-			if once {
-				return
+				die(err.Error())
 			}
 		}
+		return
 	}
-}
 
-func (a *App) setStatusBar(style string) {
-	go func() {
-		if a.statusBar != nil {
-			_ = a.statusBar.Set(context.Background(), style)
-		}
-	}()
-}
+	if junitPath != "" && len(cs) > 1 {
+		die("-junit writes one task's result, pick it with -task")
+	}
 
-func (a *App) report(c Config, res BuildResult) error {
-	if res.Error == nil {
-		if c.StatusFile != nil {
-			writeStatus(*c.StatusFile, fmt.Sprintf("ok\n\n%v", res.Output))
-			a.setStatusBar(StatusBarBlack)
-		}
-	} else {
-		if c.StatusFile != nil {
-			writeStatus(*c.StatusFile, fmt.Sprintf("FAILED\n\n%v", res.Output))
-			a.setStatusBar(StatusBarRed)
+	if daemon && logFile == "" {
+		logFile = DaemonLogPath(cpath)
+	} else if daemon {
+		// Absolute to match the paths watchers report.
+		abs, err := filepath.Abs(logFile)
+		if err != nil {
+			die(err.Error())
 		}
+		logFile = abs
 	}
-	if res.Error == nil {
-		logInfo("✓")
-	} else {
-		logInfo("✗ build failed: %v %v", res.Error, res.Output)
+	if daemon && !isDaemonChild() {
+		err := daemonize(cs, logFile)
+		if err != nil {
+			die(err.Error())
+		}
+		return
 	}
-	return nil
-}
 
-func generate() error {
-	// Make sure a config doesn't already exist in this directory.
-	_, err := FindConfig(1)
-	switch err.(type) {
-	case ConfigNotFoundError:
-		// good
-	case nil:
-		return fmt.Errorf("Config already exists in this directory")
-	default:
-		return err
+	for _, c := range cs {
+		lock, err := AcquireLock(c.ConfigPath, c.Task)
+		switch err := err.(type) {
+		case nil:
+		case LockHeldError:
+			a.releaseLocks()
+			die(fmt.Sprintf("%v\nRefusing to start a second builderator for %v", err, describeTask(c)))
+		default:
+			a.releaseLocks()
+			die2("Could not acquire lock", err)
+		}
+		a.locks = append(a.locks, lock)
 	}
+	defer a.releaseLocks()
 
-	cwd, err := os.Getwd()
-	if err != nil {
-		return err
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	interrupted := make(chan struct{})
+	go func() {
+		<-sigCh
+		logInfo("interrupted")
+		close(interrupted)
+		cancel()
+	}()
+
+	for _, c := range cs {
+		engine.PrintConfig(c)
+	}
+	if buildCmd != "" {
+		logInfo("BuildCmd overridden by -cmd")
 	}
-	cpath := path.Join(cwd, CONF_NAME)
-	return ioutil.WriteFile(cpath, []byte(STARTER_CONFIG), 0644)
-}
 
-// Kick off a single build run.
-// Returns channels to get the result and to abort the build.
-// A single result is always returned on the resultCh even when aborted.
-func build(c Config) (<-chan BuildResult, chan<- struct{}) {
-	resultCh := make(chan BuildResult, 1)
-	abortCh := make(chan struct{}, 1)
+	var runners []*engine.Runner
+	for _, c := range cs {
+		if wait {
+			c.BuildOnStart = false
+		}
+		runner := engine.NewRunner(c)
+		runner.Once = once
+		runner.BuildCmd = buildCmd
+		runner.WatchOnly = watchOnly
+		runner.ClearScreen = clearScreen
+		runner.Explain = explain
+		if daemon {
+			runner.IgnorePaths = []string{logFile}
+		}
+		runners = append(runners, runner)
+	}
+	aggregate(cs, runners)
 
-	// Replace the target with justasec.
-	if c.BuildFile != nil {
-		err := justasec(*c.BuildFile)
+	if engine.IsTerminal(os.Stdin) {
+		restoreTerminal, err := readKeys(func() { triggerAll(runners) }, sigCh)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Could not replace with justasec: %v\n", err)
+			logWarn("Keybindings disabled: %v", err)
+		} else {
+			defer restoreTerminal()
+			logInfo("Press r to rebuild, q to quit")
 		}
 	}
 
-	cmd := exec.Command("bash", "-c", c.BuildCmd)
-	cmd.Dir = c.BuildCmdDir
-	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	errs := runAll(ctx, runners)
+	for _, err := range errs {
+		logErr("%v", err)
+	}
+	if len(errs) > 0 {
+		// Return rather than die so the deferred cleanup runs.
+		a.exitCode = 1
+		return
+	}
 
-	var stdout bytes.Buffer
-	cmd.Stdout = &stdout
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
+	if wait {
+		select {
+		case <-interrupted:
+			logInfo("No change before being interrupted")
+			a.exitCode = WAIT_INTERRUPTED_EXIT_CODE
+			return
+		default:
+		}
+		for _, runner := range runners {
+			res := runner.LastResult()
+			if res == nil {
+				a.exitCode = WAIT_INTERRUPTED_EXIT_CODE
+			} else if code := buildExitCode(*res); code != 0 && a.exitCode == 0 {
+				a.exitCode = code
+			}
+		}
+	}
 
-	err := cmd.Start()
-	if err != nil {
-		resultCh <- BuildResult{
-			Error:  fmt.Errorf("Build failed to start: %v", err),
-			Output: "",
+	if junitPath != "" {
+		res := runners[0].LastResult()
+		if res == nil {
+			logErr("No build finished, not writing %v", junitPath)
+			a.exitCode = 1
+			return
+		}
+		err = writeJUnit(junitPath, cs[0], *res)
+		if err != nil {
+			logErr("Could not write JUnit file: %v", err)
+			a.exitCode = 1
 		}
-		return resultCh, abortCh
 	}
+}
 
-	var sendResultOnce sync.Once
+// The exit code of a build's command, or 1 if it failed some other way.
+func buildExitCode(res engine.BuildResult) int {
+	if res.Error == nil {
+		return 0
+	}
+	if exitErr, ok := res.Error.(*exec.ExitError); ok && exitErr.ExitCode() > 0 {
+		return exitErr.ExitCode()
+	}
+	return 1
+}
 
-	// Receiver for aborting
-	go func() {
-		<-abortCh
-		pgid, err := syscall.Getpgid(cmd.Process.Pid)
-		if err == nil {
-			syscall.Kill(-pgid, 15)
-		}
-		sendResultOnce.Do(func() {
-			cmd.Wait()
-			resultCh <- BuildResult{
-				Error:  fmt.Errorf("Build canceled"),
-				Output: "",
-			}
-		})
-	}()
+func (a *App) releaseLocks() {
+	for _, lock := range a.locks {
+		lock.Release()
+	}
+	a.locks = nil
+}
 
-	// Receiver for completion
-	go func() {
-		exit := cmd.Wait()
-		sendResultOnce.Do(func() {
-			resultCh <- BuildResult{
-				Error:  exit,
-				Output: fmt.Sprintf("%v%v", string(stdout.Bytes()), string(stderr.Bytes())),
+// Run every runner until ctx is canceled, or until they have all finished with -o.
+// If one fails to start the others are stopped.
+// Returns the errors from runners that failed.
+func runAll(ctx context.Context, runners []*engine.Runner) []error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errCh := make(chan error, len(runners))
+	for _, runner := range runners {
+		go func(runner *engine.Runner) {
+			err := runner.Run(ctx)
+			if err != nil {
+				cancel()
 			}
-		})
-	}()
-
-	return resultCh, abortCh
+			errCh <- err
+		}(runner)
+	}
+	var errs []error
+	for range runners {
+		if err := <-errCh; err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
 }
 
-// Spawn a process to watch a directory for changes.
-// Sends into the `ch` whenever there is a change.
-// Returns quick.
-func watch(ch chan<- struct{}, watchDir string) error {
-	cmd := exec.Command("fswatch", watchDir,
-		"--event", "Updated",
-		"--latency", "0.101",
-		"--one-per-batch")
-	cmd.Dir = watchDir
-
-	outReader, err := cmd.StdoutPipe()
-	if err != nil {
-		panic(err)
+// Combine the states of tasks that share a status bar port into one color,
+// and the states of every task into AggregateStatusFile if it's set.
+func aggregate(cs []engine.Config, runners []*engine.Runner) {
+	if len(cs) < 2 {
+		return
 	}
-	outScanner := bufio.NewScanner(outReader)
-
-	go func() {
-		for outScanner.Scan() {
-			_ = outScanner.Text()
-			ch <- struct{}{}
+	var ports []int
+	byPort := make(map[int][]int)
+	for i, c := range cs {
+		if c.StatusBarPort == 0 {
+			continue
 		}
-	}()
+		if _, ok := byPort[c.StatusBarPort]; !ok {
+			ports = append(ports, c.StatusBarPort)
+		}
+		byPort[c.StatusBarPort] = append(byPort[c.StatusBarPort], i)
+	}
+	for _, port := range ports {
+		shared := byPort[port]
+		if len(shared) < 2 {
+			continue
+		}
+		var tasks []string
+		for _, i := range shared {
+			tasks = append(tasks, cs[i].Task)
+		}
+		a := engine.NewAggregator(tasks)
+		a.StatusBar = engine.NewStatusBar(port)
+		a.StatusBar.Proto = cs[shared[0]].StatusBarProto
+		a.StatusBar.KeepAlive = cs[shared[0]].StatusBarKeepAlive
+		a.StatusBar.Timeout = cs[shared[0]].StatusBarTimeout
+		a.StatusBarColors = cs[shared[0]].StatusBarColors
+		for _, i := range shared {
+			runners[i].Aggregators = append(runners[i].Aggregators, a)
+		}
+	}
 
-	return cmd.Start()
+	// Tasks inherit it from the top of the config, so the first task's is everyone's.
+	if cs[0].AggregateStatusFile != nil {
+		var tasks []string
+		for _, c := range cs {
+			tasks = append(tasks, c.Task)
+		}
+		a := engine.NewAggregator(tasks)
+		a.StatusFile = cs[0].AggregateStatusFile
+		a.StatusFileMode = cs[0].StatusFileMode
+		a.StatusWriteInterval = cs[0].StatusWriteInterval
+		for _, runner := range runners {
+			runner.Aggregators = append(runner.Aggregators, a)
+		}
+	}
 }
 
-func monitor(c Config) {
-	if c.StatusFile == nil {
-		die("Config.StatusFile required for Monitor mode")
+// Rebuild every task.
+func triggerAll(runners []*engine.Runner) {
+	for _, runner := range runners {
+		go runner.Trigger()
 	}
+}
 
-	binary, err := exec.LookPath("watch")
-	if err != nil {
-		die(fmt.Sprintf("could not find watch program: %s", err))
+// Pick the tasks named in a comma separated list, or all of them if it's empty.
+func selectTasks(cs []engine.Config, names string) ([]engine.Config, error) {
+	if names == "" {
+		return cs, nil
+	}
+	var selected []engine.Config
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		found := false
+		for _, c := range cs {
+			if c.Task == name && name != "" {
+				selected = append(selected, c)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no task named %q, the config has %v", name, describeTasks(cs))
+		}
 	}
+	return selected, nil
+}
 
-	// TODO this is broken
-	die("not implemented")
+func describeTasks(cs []engine.Config) string {
+	var names []string
+	for _, c := range cs {
+		if c.Task != "" {
+			names = append(names, c.Task)
+		}
+	}
+	if len(names) == 0 {
+		return "no tasks"
+	}
+	return "tasks " + strings.Join(names, ", ")
+}
 
-	env := os.Environ()
-	args := []string{binary, "-n", ".1", *c.StatusFile}
-	log.Printf("%+v", args)
-	err = syscall.Exec(binary, args, env)
-	if err != nil {
-		die(fmt.Sprintf("error running watch: %s", err))
+func describeTask(c engine.Config) string {
+	if c.Task == "" {
+		return c.ConfigPath
 	}
+	return fmt.Sprintf("task %v of %v", c.Task, c.ConfigPath)
 }
 
-func writeStatus(path string, status string) {
-	b := []byte(status)
-	err := ioutil.WriteFile(path, b, 0644)
+// Print the recent builds of the builderator running for c.
+// Asks its control server, so c needs a ControlPort.
+func printHistory(c engine.Config) error {
+	if c.ControlPort == 0 {
+		return fmt.Errorf("set ControlPort in the config to keep a history")
+	}
+	url := fmt.Sprintf("http://%v/history", net.JoinHostPort(c.ControlHost, strconv.Itoa(c.ControlPort)))
+	resp, err := http.Get(url)
 	if err != nil {
-		logInfo("WARN: could not write to status file\n")
+		return fmt.Errorf("is builderator running? %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%v returned %v", url, resp.Status)
 	}
+	var history []engine.HistoryEntry
+	err = json.NewDecoder(resp.Body).Decode(&history)
+	if err != nil {
+		return err
+	}
+	if len(history) == 0 {
+		logInfo("No builds yet")
+	}
+	for _, e := range history {
+		line := fmt.Sprintf("%v  %-6v  %6.1fs  %v", e.Finished.Format("2006-01-02 15:04:05"), e.State, e.DurationSeconds, e.Error)
+		logInfo("%v", strings.TrimSpace(line))
+	}
+	return nil
 }
 
-func justasec(binpath string) error {
-	jaspath, err := which("justasec")
+// Print the resolved config as JSON, or a list of them for a config with tasks.
+// Unset optional values are null.
+func printConfigJSON(cs []engine.Config) error {
+	var v interface{} = cs
+	if len(cs) == 1 && cs[0].Task == "" {
+		v = cs[0]
+	}
+	b, err := json.MarshalIndent(v, "", "  ")
 	if err != nil {
 		return err
 	}
-	if jaspath == nil {
-		return fmt.Errorf("could not find 'jusatsec' in PATH")
-	}
-	cmd := exec.Command("cp", *jaspath, binpath)
-	return cmd.Run()
+	fmt.Println(string(b))
+	return nil
 }
 
-// which finds the full path of an executable.
-// Similar to `which` in bash but not perfect.
-// Does not ignore files that you don't have permission to execute if anyone does.
-// Fumbles relative paths.
-func which(name string) (*string, error) {
-	directlyExecutable, err := isExecutable(name)
+// Print where StatusFile is and what's in it.
+func printStatusFile(c engine.Config) {
+	if c.StatusFile == nil {
+		logInfo("StatusFile: None, set StatusFile in the config to write one")
+		return
+	}
+	logInfo("StatusFile:\n  %v\n", *c.StatusFile)
+	b, err := ioutil.ReadFile(*c.StatusFile)
+	if os.IsNotExist(err) {
+		logInfo("(does not exist yet, is builderator running?)")
+		return
+	}
 	if err != nil {
-		return nil, err
+		logWarn("could not read StatusFile: %v", err)
+		return
 	}
-	if directlyExecutable {
-		path, err := filepath.Abs(name)
-		if err != nil {
-			return nil, err
+	var pretty bytes.Buffer
+	if json.Indent(&pretty, b, "", "  ") == nil {
+		b = pretty.Bytes()
+	}
+	fmt.Println(strings.TrimRight(string(b), "\n"))
+}
+
+// The warnings from reading a config, as TaskErrors for a task.
+func configWarnings(c engine.Config) []error {
+	var warns []error
+	for _, err := range c.Warnings {
+		if c.Task != "" {
+			err = engine.TaskError{Task: c.Task, Err: err}
 		}
-		return &path, nil
+		warns = append(warns, err)
 	}
-	pathDirs := strings.Split(os.Getenv("PATH"), string(os.PathListSeparator))
-	for _, dirPath := range pathDirs {
-		info, err := os.Stat(dirPath)
-		if err != nil {
-			if os.IsNotExist(err) {
-				continue
-			}
-			return nil, fmt.Errorf("error in stat %v: %v", dirPath, err)
+	return warns
+}
+
+// Log missing directories in a config error as warnings.
+// Returns whatever other errors remain.
+func warnDirErrors(err error) error {
+	errs, ok := err.(engine.ConfigErrors)
+	if !ok {
+		return err
+	}
+	var rest engine.ConfigErrors
+	for _, err := range errs {
+		inner := err
+		if te, ok := err.(engine.TaskError); ok {
+			inner = te.Err
 		}
-		if !info.IsDir() {
+		if _, ok := inner.(engine.DirError); ok {
+			logWarn("%v", err)
 			continue
 		}
-		files, err := ioutil.ReadDir(dirPath)
-		if err != nil {
-			return nil, fmt.Errorf("error in read dir %v: %v", dirPath, err)
+		rest = append(rest, err)
+	}
+	if len(rest) == 0 {
+		return nil
+	}
+	return rest
+}
+
+// Check a config and report every problem found.
+// Returns whether the config is ok.
+func validateConfig(cpath string) bool {
+	fmt.Fprintf(os.Stderr, "Config path:\n  %v\n", cpath)
+	cs, err := engine.ReadTasks(cpath)
+	var errs engine.ConfigErrors
+	switch err := err.(type) {
+	case nil:
+	case engine.ConfigErrors:
+		errs = append(errs, err...)
+	default:
+		fmt.Fprintf(os.Stderr, "✗ %v\n", err)
+		return false
+	}
+	for _, c := range cs {
+		for _, err := range configWarnings(c) {
+			fmt.Fprintf(os.Stderr, "! %v\n", err)
 		}
-		for _, f := range files {
-			if f.Name() != name {
-				continue
-			}
-			path, err := filepath.Abs(filepath.Join(dirPath, f.Name()))
-			if err != nil {
-				return nil, err
-			}
-			executable, err := isExecutable(path)
-			if err != nil {
-				return nil, err
-			}
-			if executable {
-				return &path, nil
+		for _, err := range engine.CheckConfig(c) {
+			if c.Task != "" {
+				err = engine.TaskError{Task: c.Task, Err: err}
 			}
+			errs = append(errs, err)
 		}
 	}
-	return nil, nil
+	for _, err := range errs {
+		fmt.Fprintf(os.Stderr, "✗ %v\n", err)
+	}
+	if len(errs) > 0 {
+		return false
+	}
+	fmt.Fprintf(os.Stderr, "✓ config ok\n")
+	return true
 }
 
-func isExecutable(path string) (bool, error) {
-	info, err := os.Stat(path)
+// Pick a starter language for the project in cwd, noting what was found.
+func detectStarterLang() string {
+	cwd, err := os.Getwd()
 	if err != nil {
-		if os.IsNotExist(err) {
-			return false, nil
+		return ""
+	}
+	lang, found := detectLang(cwd)
+	if len(found) > 0 {
+		fmt.Fprintf(os.Stderr, "Found %v, generating a %v config. Use -lang to pick another.\n", strings.Join(found, ", "), lang)
+	}
+	return lang
+}
+
+func generate(name string, lang string) error {
+	starter, err := starterConfig(lang)
+	if err != nil {
+		return err
+	}
+
+	return writeNewConfig(name, starter)
+}
+
+// Ask for the config values and write a config tailored to them.
+// With `yes` takes the defaults without asking.
+func initConfig(name string, yes bool) error {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	// Don't ask questions only to refuse the answers.
+	err = checkNoConfig(name)
+	if err != nil {
+		return err
+	}
+	answers := initDefaults(cwd)
+	if !yes {
+		answers, err = promptInit(os.Stdin, os.Stdout, cwd, answers)
+		if err != nil {
+			return err
 		}
-		return false, err
 	}
-	if runtime.GOOS == "windows" {
-		return true, nil
+	err = writeNewConfig(name, answers.config())
+	if err != nil {
+		return err
+	}
+	logInfo("Wrote %v", name)
+	return nil
+}
+
+// Make sure a config named `name` doesn't already exist in cwd.
+func checkNoConfig(name string) error {
+	_, err := FindConfig(name, 1, false)
+	switch err.(type) {
+	case ConfigNotFoundError:
+		return nil
+	case nil:
+		return fmt.Errorf("Config already exists in this directory")
+	default:
+		return err
+	}
+}
+
+// Write a config named `name` in cwd, unless one is already there.
+func writeNewConfig(name string, contents string) error {
+	err := checkNoConfig(name)
+	if err != nil {
+		return err
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	cpath := path.Join(cwd, name)
+	return ioutil.WriteFile(cpath, []byte(contents), 0644)
+}
+
+func monitor(c engine.Config) {
+	if c.StatusFile == nil {
+		die("Config.StatusFile required for Monitor mode")
+	}
+
+	binary, err := exec.LookPath("watch")
+	if err != nil {
+		die(fmt.Sprintf("could not find watch program: %s", err))
+	}
+
+	// TODO this is broken
+	die("not implemented")
+
+	env := os.Environ()
+	args := []string{binary, "-n", ".1", *c.StatusFile}
+	logInfo("%+v", args)
+	err = syscall.Exec(binary, args, env)
+	if err != nil {
+		die(fmt.Sprintf("error running watch: %s", err))
 	}
-	return info.Mode()&0111 != 0, nil
 }
 
 func die(reason string) {
-	fmt.Fprintf(os.Stderr, "%v\n", reason)
+	logErr("%v", reason)
 	os.Exit(1)
 }
 
 func die2(reason string, err error) {
-	fmt.Fprintf(os.Stderr, "%v: %v\n", reason, err)
+	logErr("%v: %v", reason, err)
 	os.Exit(1)
 }
 
+// Logs through the engine so -q and -log-format apply to the CLI as well.
 func logInfo(format string, args ...interface{}) {
-	fmt.Printf(format+"\n", args...)
+	engine.LogInfo(format, args...)
+}
+
+func logWarn(format string, args ...interface{}) {
+	engine.LogWarn(format, args...)
+}
+
+// Errors go to stderr, or into the json log with -log-format json.
+func logErr(format string, args ...interface{}) {
+	if engine.LogFormat() == engine.LOG_FORMAT_JSON {
+		engine.LogError(format, args...)
+		return
+	}
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
 }
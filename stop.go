@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+
+	"github.com/mlsteele/builderator/engine"
+)
+
+// How long `builderator stop` waits after SIGTERM before sending SIGKILL.
+const STOP_GRACE_PERIOD = 10 * time.Second
+
+// Stop the builderators running the configs, found by their lock files.
+// A process running several of them is only stopped once.
+// Returns an error if none of them are running.
+func stopRunning(cs []engine.Config) error {
+	var pids []int
+	seen := make(map[int]bool)
+	for _, c := range cs {
+		pid, err := LockHolder(c.ConfigPath, c.Task)
+		if err != nil {
+			return err
+		}
+		if pid == 0 {
+			if len(cs) > 1 {
+				logInfo("No builderator running for %v", describeTask(c))
+			}
+			continue
+		}
+		if !seen[pid] {
+			seen[pid] = true
+			pids = append(pids, pid)
+		}
+	}
+	if len(pids) == 0 && len(cs) == 1 {
+		return fmt.Errorf("no builderator is running for %v", describeTask(cs[0]))
+	}
+	if len(pids) == 0 {
+		return fmt.Errorf("no builderator is running for %v of %v", describeTasks(cs), cs[0].ConfigPath)
+	}
+	for _, pid := range pids {
+		err := stopPid(pid, STOP_GRACE_PERIOD)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Send SIGTERM to pid and wait for it to exit, escalating to SIGKILL after `grace`.
+func stopPid(pid int, grace time.Duration) error {
+	logInfo("Stopping builderator (pid %v)", pid)
+	err := syscall.Kill(pid, syscall.SIGTERM)
+	if err == syscall.ESRCH {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not signal pid %v: %v", pid, err)
+	}
+	if waitExit(pid, grace) {
+		logInfo("Stopped")
+		return nil
+	}
+	logWarn("pid %v still running %v after SIGTERM, sending SIGKILL", pid, grace)
+	err = syscall.Kill(pid, syscall.SIGKILL)
+	if err != nil && err != syscall.ESRCH {
+		return fmt.Errorf("could not kill pid %v: %v", pid, err)
+	}
+	if !waitExit(pid, time.Second) {
+		return fmt.Errorf("pid %v did not exit after SIGKILL", pid)
+	}
+	logInfo("Killed")
+	return nil
+}
+
+// Wait up to `timeout` for pid to exit. Returns whether it did.
+func waitExit(pid int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for pidAlive(pid) {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return true
+}
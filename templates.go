@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Starter config for -g -lang, filled in from a langStarter.
+const LANG_STARTER_CONFIG = `# All relative paths are relative to this config file.
+# Starter config for %v.%v
+
+# Directory to watch for changes.
+WatchDir    = %q
+
+# Command to run when files change. (Can be a script like "./compile.sh")
+BuildCmd    = %q
+
+# (Optional) Working directory for BuildCmd.
+BuildCmdDir = "."
+
+# (Optional) File to write build status and output to.
+StatusFile  = "/tmp/buildstatus-builderator"
+
+# (Optional) UDP Port for controlling AnyBar.
+StatusBarPort = 1738
+`
+
+type langStarter struct {
+	WatchDir string
+	BuildCmd string
+	// Extra comment lines for the top of the config.
+	Note string
+}
+
+var langStarters = map[string]langStarter{
+	"go": {
+		WatchDir: ".",
+		BuildCmd: "go build ./... && go test ./...",
+	},
+	"node": {
+		WatchDir: "src",
+		BuildCmd: "npm run build",
+		Note:     "\n# Watches src so that installs into node_modules don't trigger builds.",
+	},
+	"python": {
+		WatchDir: ".",
+		BuildCmd: "python -m pytest -q",
+	},
+	"rust": {
+		WatchDir: "src",
+		BuildCmd: "cargo build && cargo test",
+		Note:     "\n# Watches src so that cargo's writes to target don't trigger builds.",
+	},
+}
+
+// Files that mark a project's language, most specific first.
+// package.json often sits beside another language's project for its frontend tooling,
+// so it comes after those.
+var langMarkers = []struct {
+	File string
+	Lang string
+}{
+	{"Cargo.toml", "rust"},
+	{"go.mod", "go"},
+	{"pyproject.toml", "python"},
+	{"setup.py", "python"},
+	{"package.json", "node"},
+}
+
+// Guess the language of the project in dir from its marker files.
+// Returns "" if there are none, along with every marker found.
+func detectLang(dir string) (string, []string) {
+	lang := ""
+	var found []string
+	for _, m := range langMarkers {
+		_, err := os.Stat(filepath.Join(dir, m.File))
+		if err != nil {
+			continue
+		}
+		found = append(found, m.File)
+		if lang == "" {
+			lang = m.Lang
+		}
+	}
+	return lang, found
+}
+
+// The starter config for a language, or STARTER_CONFIG for "".
+func starterConfig(lang string) (string, error) {
+	if lang == "" {
+		return STARTER_CONFIG, nil
+	}
+	s, ok := langStarters[lang]
+	if !ok {
+		return "", fmt.Errorf("no starter config for %q, choose from %v", lang, strings.Join(starterLangs(), ", "))
+	}
+	return fmt.Sprintf(LANG_STARTER_CONFIG, lang, s.Note, s.WatchDir, s.BuildCmd), nil
+}
+
+// The languages with starter configs, sorted.
+func starterLangs() []string {
+	var langs []string
+	for lang := range langStarters {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
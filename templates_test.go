@@ -0,0 +1,49 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mlsteele/builderator/engine"
+)
+
+// Every starter config should read back cleanly.
+func TestStarterConfigsParse(t *testing.T) {
+	for _, lang := range append([]string{""}, starterLangs()...) {
+		starter, err := starterConfig(lang)
+		if err != nil {
+			t.Fatalf("%q: %v", lang, err)
+		}
+		dir, err := ioutil.TempDir("", "builderator-starter")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		err = os.Mkdir(filepath.Join(dir, "src"), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cpath := filepath.Join(dir, CONF_NAME)
+		err = ioutil.WriteFile(cpath, []byte(starter), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := engine.ReadConfig(cpath)
+		if err != nil {
+			t.Errorf("%q: could not read starter config: %v", lang, err)
+			continue
+		}
+		if lang != "" && c.BuildCmd != langStarters[lang].BuildCmd {
+			t.Errorf("%q: BuildCmd %q, expected %q", lang, c.BuildCmd, langStarters[lang].BuildCmd)
+		}
+	}
+}
+
+func TestStarterConfigUnknownLang(t *testing.T) {
+	_, err := starterConfig("cobol")
+	if err == nil {
+		t.Fatal("expected an error for an unknown language")
+	}
+}